@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
 	"sync/atomic"
 	"time"
 
@@ -19,6 +20,9 @@ type BenchmarkResult struct {
 	FailedInserts     int64
 }
 
+// Maximum amount of inserts sharing a transaction in the group commit scenarios
+const groupCommitSize = 256
+
 func runBenchmark(iterations int, duration time.Duration) (*BenchmarkResult, error) {
 	// Create a new ComfyDB instance
 	comfy, err := comfylite3.New(
@@ -83,6 +87,91 @@ func runBenchmark(iterations int, duration time.Duration) (*BenchmarkResult, err
 	}, nil
 }
 
+// Scenario of the group commit benchmark
+type groupCommitScenario struct {
+	name  string
+	opts  []comfylite3.ComfyOption
+	batch bool
+}
+
+// Queue every insert at once and wait for all of them, so the worker has consecutive items to coalesce.
+func runGroupCommitBenchmark(scenario groupCommitScenario, inserts int) (*BenchmarkResult, error) {
+	path := fmt.Sprintf("bench_%d.db", time.Now().UnixNano())
+	defer func() {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(path + suffix)
+		}
+	}()
+
+	comfy, err := comfylite3.New(append([]comfylite3.ComfyOption{comfylite3.WithPath(path)}, scenario.opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ComfyDB: %v", err)
+	}
+	defer comfy.Close()
+
+	createID := comfy.New(func(db *sql.DB) (interface{}, error) {
+		_, err := db.Exec(`CREATE TABLE IF NOT EXISTS benchmark (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			value TEXT,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+		return nil, err
+	})
+	if err := waitForResult(comfy, createID); err != nil {
+		return nil, fmt.Errorf("failed to create table: %v", err)
+	}
+
+	var successCount, failCount atomic.Int64
+	startTime := time.Now()
+
+	fns := make([]comfylite3.SqlFn, inserts)
+	for i := range fns {
+		value := fmt.Sprintf("test_value_%d", i)
+		fns[i] = func(db *sql.DB) (interface{}, error) {
+			_, err := db.Exec("INSERT INTO benchmark (value) VALUES (?)", value)
+			return nil, err
+		}
+	}
+
+	ids := []uint64{}
+	if scenario.batch {
+		for start := 0; start < len(fns); start += groupCommitSize {
+			end := start + groupCommitSize
+			if end > len(fns) {
+				end = len(fns)
+			}
+			ids = append(ids, comfy.SubmitBatch(fns[start:end]...)...)
+		}
+	} else {
+		// The inserts are allowed to share a transaction when group commit is enabled
+		ctx := comfylite3.ContextWithGroupCommit(context.Background())
+		for _, fn := range fns {
+			fn := fn
+			ids = append(ids, comfy.NewContext(ctx, func(ctx context.Context, db *sql.DB) (interface{}, error) {
+				return fn(db)
+			}))
+		}
+	}
+
+	for _, id := range ids {
+		if err := waitForResult(comfy, id); err != nil {
+			failCount.Add(1)
+		} else {
+			successCount.Add(1)
+		}
+	}
+
+	elapsedSeconds := time.Since(startTime).Seconds()
+
+	return &BenchmarkResult{
+		TotalInserts:      inserts,
+		DurationSeconds:   elapsedSeconds,
+		InsertsPerSecond:  float64(inserts) / elapsedSeconds,
+		SuccessfulInserts: successCount.Load(),
+		FailedInserts:     failCount.Load(),
+	}, nil
+}
+
 func waitForResult(comfy *comfylite3.ComfyDB, id uint64) error {
 	result := <-comfy.WaitForChn(id)
 	if err, ok := result.(error); ok {
@@ -129,4 +218,26 @@ func main() {
 	} else {
 		fmt.Println("No successful benchmark runs completed")
 	}
+
+	scenarios := []groupCommitScenario{
+		{name: "one transaction per insert"},
+		{name: "group commit", opts: []comfylite3.ComfyOption{comfylite3.WithGroupCommit(groupCommitSize)}},
+		{name: "SubmitBatch", batch: true},
+	}
+
+	fmt.Printf("\nRunning group commit scenarios on a file database with %d queued inserts each...\n\n", insertCount)
+
+	for _, scenario := range scenarios {
+		result, err := runGroupCommitBenchmark(scenario, insertCount)
+		if err != nil {
+			log.Printf("Scenario %q failed: %v\n", scenario.name, err)
+			continue
+		}
+
+		fmt.Printf("Scenario %s:\n", scenario.name)
+		fmt.Printf("  Duration: %.2f seconds\n", result.DurationSeconds)
+		fmt.Printf("  Inserts/second: %.2f\n", result.InsertsPerSecond)
+		fmt.Printf("  Successful: %d\n", result.SuccessfulInserts)
+		fmt.Printf("  Failed: %d\n\n", result.FailedInserts)
+	}
 }
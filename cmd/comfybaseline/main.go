@@ -0,0 +1,53 @@
+// Command comfybaseline writes the schema of a database as a baseline SQL file.
+//
+//	comfybaseline -db ./app.db -out ./migrations/baseline.sql
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/davidroman0O/comfylite3"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	path := flag.String("db", "", "path of the database file")
+	out := flag.String("out", "", "path of the baseline file, stdout when empty")
+	table := flag.String("table", "_migrations", "name of the migration table")
+	flag.Parse()
+
+	if *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if _, err := os.Stat(*path); err != nil {
+		log.Fatal(err)
+	}
+
+	// Read-only, the database is left as it is: no migration table, no change of journal mode
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", *path))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := comfylite3.WriteBaselineFrom(context.Background(), db, *table, w); err != nil {
+		log.Fatal(err)
+	}
+}
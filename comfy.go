@@ -3,8 +3,10 @@ package comfylite3
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"math"
+	"runtime/debug"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -17,10 +19,45 @@ import (
 // Callback provided by a developer to be executed when the scheduler is ready for it
 type SqlFn func(db *sql.DB) (interface{}, error)
 
+// Callback provided by a developer that receives the context it was submitted with
+type SqlContextFn func(ctx context.Context, db *sql.DB) (interface{}, error)
+
 type workItem struct {
-	id     uint64
-	fn     SqlFn
+	id       uint64
+	ctx      context.Context
+	fn       SqlContextFn
+	priority Priority
+	sched    *scheduler
+	attempts int
+	// abandoned is set when close failed the work item while it waited for a retry, guarded by the scheduler
+	abandoned bool
+	// solo work never shares a group commit transaction, it manages its own transaction or returns open rows
+	solo bool
+	// group is set when the submitter allowed the work to join a group commit, see ContextWithGroupCommit
+	group  bool
 	result chan interface{}
+	// complete receives the outcome instead of the result channel when set
+	complete func(value interface{}, err error)
+	// completedAt is the unix nano time the outcome was delivered, zero until then
+	completedAt atomic.Int64
+}
+
+// Deliver the outcome of the work to whoever is waiting for it and release its slot in the scheduler.
+func (w *workItem) done(value interface{}, err error) {
+	w.completedAt.Store(time.Now().UnixNano())
+	if w.complete != nil {
+		w.complete(value, err)
+	} else {
+		if err != nil {
+			w.result <- err
+		} else {
+			w.result <- value
+		}
+		close(w.result)
+	}
+	if w.sched != nil {
+		w.sched.finish()
+	}
 }
 
 // Default Memory Connection
@@ -29,24 +66,10 @@ const memoryConn = "file::memory:?_mutex=full&cache=shared&_timeout=5000"
 // Default File Connection
 const fileConn = "file:%s?cache=shared&mode=rwc&_journal_mode=WAL&_timeout=5000"
 
-type onPanic func(v interface{}, stackTrace string)
-
-type Migration struct {
-	Version uint
-	Label   string
-	Up      func(tx *sql.Tx) error
-	Down    func(tx *sql.Tx) error
-}
+// Default Read-Only File Connection, with a private cache so readers don't wait for the writer in WAL mode
+const readFileConn = "file:%s?mode=ro&_timeout=5000"
 
-// Create a new migration with a version, label, and up and down functions.
-func NewMigration(version uint, label string, up, down func(tx *sql.Tx) error) Migration {
-	return Migration{
-		Version: version,
-		Label:   label,
-		Up:      up,
-		Down:    down,
-	}
-}
+type onPanic func(v interface{}, stackTrace string)
 
 // ComfyDB is a wrapper around sqlite3 that provides a simple API for executing SQL queries with goroutines.
 type ComfyDB struct {
@@ -55,7 +78,24 @@ type ComfyDB struct {
 	results sync.Map
 
 	migrations         []Migration
+	migrationSources   []migrationSource
 	migrationTableName string
+	migrationPerTx     bool
+	onMigrationDrift   func(drift MigrationDrift)
+	migrationLease     time.Duration
+	migrationLockWait  time.Duration
+	lockOwner          string
+	migrationHooks     MigrationHooks
+	baseline           *Migration
+
+	snapshotDir       string
+	snapshotInterval  time.Duration
+	snapshotRetention SnapshotRetention
+	onSnapshotError   func(err error)
+	stopSnapshots     chan struct{}
+	stopSnapshotsOnce sync.Once
+	snapshotsDone     chan struct{}
+	restoreMu         sync.Mutex
 
 	memory bool
 	driver string
@@ -64,6 +104,22 @@ type ComfyDB struct {
 
 	pool        *retrypool.Pool[*workItem]
 	poolOptions []retrypool.Option[*workItem]
+	writer      *scheduler
+	burst       int
+	groupCommit int
+	onPanic     onPanic
+
+	retryAttempts int
+	retryDelay    time.Duration
+	retryable     RetryClassifier
+
+	resultTTL   time.Duration
+	stopSweeper chan struct{}
+
+	readers  int
+	readDB   *sql.DB
+	readPool *retrypool.Pool[*workItem]
+	reader   *scheduler
 }
 
 type ComfyOption func(*ComfyDB)
@@ -97,6 +153,40 @@ func WithConnection(conn string) ComfyOption {
 	}
 }
 
+// WithReadPool opens a second read-only connection with n readers for the queries, next to the single writer.
+// It requires a file database, sqlite only allows concurrent readers and a writer with WAL.
+func WithReadPool(n int) ComfyOption {
+	return func(c *ComfyDB) {
+		c.readers = n
+	}
+}
+
+// WithPriorityBurst sets how many higher priority items are served before a waiting lower priority item gets its turn.
+func WithPriorityBurst(n int) ComfyOption {
+	return func(c *ComfyDB) {
+		c.burst = n
+	}
+}
+
+// WithResultTTL removes the results nobody claimed with WaitFor or WaitForChn after the duration.
+func WithResultTTL(ttl time.Duration) ComfyOption {
+	return func(c *ComfyDB) {
+		c.resultTTL = ttl
+	}
+}
+
+// WithGroupCommit lets the writer execute up to max consecutive queued items within a single transaction.
+// Each item gets its own savepoint, a failing item only rolls back its own changes.
+// Only the work submitted with a context from ContextWithGroupCommit joins a group, the rest runs on its own.
+// Grouped work runs inside the transaction, so it must not begin its own transaction nor return open rows,
+// the PRAGMAs that are no-ops within a transaction (foreign_keys, journal_mode...) are ignored,
+// and it isn't retried: a retryable error fails the item.
+func WithGroupCommit(max int) ComfyOption {
+	return func(c *ComfyDB) {
+		c.groupCommit = max
+	}
+}
+
 func WithDriver(driver string) ComfyOption {
 	return func(o *ComfyDB) {
 		o.driver = driver
@@ -110,71 +200,162 @@ func WithMigration(migrations ...Migration) ComfyOption {
 	}
 }
 
-// WithRetryAttempts sets maximum retry attempts for failed operations
+// WithTransactionPerMigration executes each migration within its own transaction instead of a single one for all of them.
+// A failing migration leaves the previous ones applied and recorded.
+func WithTransactionPerMigration() ComfyOption {
+	return func(c *ComfyDB) {
+		c.migrationPerTx = true
+	}
+}
+
+// WithMigrationDriftHandler lets Up and MigrateTo go on when an applied migration changed since, the handler is told about it.
+// Without handler they refuse to migrate and return the drifts.
+func WithMigrationDriftHandler(handler func(drift MigrationDrift)) ComfyOption {
+	return func(c *ComfyDB) {
+		c.onMigrationDrift = handler
+	}
+}
+
+// WithMigrationLock makes Up, Down and MigrateTo hold a lock shared by every process using the database file.
+// The lease is extended while the migrations run, so a crashed process releases the lock once its lease expired.
+// A migration transaction fails with ErrMigrationInProgress when the lease expired and another process took the lock.
+// The other callers wait for it up to wait before failing with ErrMigrationInProgress, zero fails right away.
+func WithMigrationLock(lease, wait time.Duration) ComfyOption {
+	return func(c *ComfyDB) {
+		c.migrationLease = lease
+		c.migrationLockWait = wait
+	}
+}
+
+// WithMigrationHooks sets the callbacks called around each migration, to log, measure or abort them.
+func WithMigrationHooks(hooks MigrationHooks) ComfyOption {
+	return func(c *ComfyDB) {
+		c.migrationHooks = hooks
+	}
+}
+
+// WithBaseline registers the full schema at the version of the baseline, applied instead of the migrations up to it on an empty database.
+// Those migrations are recorded as applied, they can be retired once every database is past the baseline.
+// A baseline has no Down, it can't be rolled back. See WriteBaseline to generate one.
+func WithBaseline(baseline Migration) ComfyOption {
+	return func(c *ComfyDB) {
+		c.baseline = &baseline
+	}
+}
+
+// WithRetryAttempts sets the maximum executions of a work item failing with a retryable error, 3 by default.
+// Zero or one executes it once without retry, UnlimitedRetries retries until it succeeds.
 func WithRetryAttempts(attempts int) ComfyOption {
 	return func(c *ComfyDB) {
-		c.poolOptions = append(c.poolOptions, retrypool.WithAttempts[*workItem](attempts))
+		c.retryAttempts = attempts
 	}
 }
 
-// WithRetryDelay sets delay between retries
+// WithRetryDelay sets delay between retries, it doubles at each retry
 func WithRetryDelay(delay time.Duration) ComfyOption {
 	return func(c *ComfyDB) {
-		c.poolOptions = append(c.poolOptions, retrypool.WithDelay[*workItem](delay))
+		c.retryDelay = delay
+	}
+}
+
+// WithRetryClassifier sets the function deciding which errors are retried, IsRetryable by default
+func WithRetryClassifier(classifier RetryClassifier) ComfyOption {
+	return func(c *ComfyDB) {
+		c.retryable = classifier
 	}
 }
 
 // WithPanicHandler sets custom panic handler
 func WithPanicHandler(handler onPanic) ComfyOption {
 	return func(c *ComfyDB) {
-		c.poolOptions = append(c.poolOptions, retrypool.WithOnWorkerPanic[*workItem](func(workerID int, recovery interface{}, stackTrace string) {
-			handler(recovery, stackTrace)
-		}))
+		c.onPanic = handler
 	}
 }
 
 // Close the database connection.
+// The work still queued, the work waiting for a retry and the work submitted afterwards fail with ErrClosed.
 func (c *ComfyDB) Close() error {
-	// Close the retrypool
-	if err := c.pool.Close(); err != nil {
-		if err != context.Canceled {
+	c.stopSnapshotting()
+
+	// Stop scheduling
+	c.writer.close()
+	if c.reader != nil {
+		c.reader.close()
+	}
+
+	return c.release()
+}
+
+// Shutdown stops accepting new work, which fails with ErrClosed, and lets the running and queued work finish.
+// When the context is done first, the work still queued fails with ErrClosed and the context error is returned.
+// A file database gets a last WAL checkpoint before the connections are closed.
+func (c *ComfyDB) Shutdown(ctx context.Context) error {
+	c.stopSnapshotting()
+
+	idle := []<-chan struct{}{c.writer.stop()}
+	if c.reader != nil {
+		idle = append(idle, c.reader.stop())
+	}
+
+	var drainErr error
+	for _, done := range idle {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			drainErr = ctx.Err()
+		}
+		if drainErr != nil {
+			break
+		}
+	}
+
+	if drainErr != nil {
+		c.writer.close()
+		if c.reader != nil {
+			c.reader.close()
+		}
+	} else if !c.memory && c.path != "" {
+		// Nothing runs anymore, the writer connection is ours
+		if _, err := c.db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			c.release()
 			return err
 		}
 	}
 
-	// Close the database connection
-	return c.db.Close()
+	if err := c.release(); err != nil {
+		return err
+	}
+	return drainErr
 }
 
-// Prepare the eventual creation of the migration table.
-func (c *ComfyDB) prepareMigration() error {
-	newTableID := c.New(func(db *sql.DB) (interface{}, error) {
-		_, err := db.Exec(fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %v (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			version INTEGER UNIQUE NOT NULL,
-			description VARCHAR(255) UNIQUE NOT NULL
-		)`, c.migrationTableName))
-		return nil, err
-	})
-	result, err := c.WaitFor(newTableID)
-	if err != nil {
-		return err
+// Close the retrypools and the database connections.
+func (c *ComfyDB) release() error {
+	if c.stopSweeper != nil {
+		close(c.stopSweeper)
+		c.stopSweeper = nil
 	}
-	if errResult, ok := result.(error); ok {
-		return errResult
+
+	// Close the retrypool
+	if err := c.pool.Close(); err != nil {
+		if err != context.Canceled {
+			return err
+		}
 	}
-	return nil
-}
 
-// Sort the migrations by version.
-func (c *ComfyDB) sort() []Migration {
-	cp := make([]Migration, len(c.migrations))
-	copy(cp, c.migrations)
-	sort.Slice(cp, func(i, j int) bool {
-		return cp[i].Version < cp[j].Version
-	})
-	return cp
+	// Close the readers
+	if c.readPool != nil {
+		if err := c.readPool.Close(); err != nil {
+			if err != context.Canceled {
+				return err
+			}
+		}
+		if err := c.readDB.Close(); err != nil {
+			return err
+		}
+	}
+
+	// Close the database connection
+	return c.db.Close()
 }
 
 // Create a new ComfyLite3 wrapper around sqlite3.
@@ -186,6 +367,10 @@ func New(opts ...ComfyOption) (*ComfyDB, error) {
 		migrationTableName: "_migrations",
 		poolOptions:        make([]retrypool.Option[*workItem], 0),
 		driver:             "sqlite3",
+		retryAttempts:      defaultRetryAttempts,
+		retryDelay:         defaultRetryDelay,
+		retryable:          IsRetryable,
+		lockOwner:          newLockOwner(),
 	}
 
 	c.count.Store(1)
@@ -194,6 +379,14 @@ func New(opts ...ComfyOption) (*ComfyDB, error) {
 		opt(c)
 	}
 
+	if err := c.loadMigrationSources(); err != nil {
+		return nil, err
+	}
+
+	if c.readers > 0 && (c.memory || c.path == "") {
+		return nil, fmt.Errorf("read pool requires a file database")
+	}
+
 	// Open the database connection
 	var err error
 	if c.conn != "" {
@@ -214,70 +407,235 @@ func New(opts ...ComfyOption) (*ComfyDB, error) {
 	c.db.SetMaxOpenConns(1)
 	c.db.SetMaxIdleConns(1)
 
+	// The attempts are counted by the failure handler, the retrypool retries until told otherwise
+	c.poolOptions = append(c.poolOptions,
+		retrypool.WithOnTaskFailure(c.onTaskFailure),
+		retrypool.WithDelayFunc(c.retryBackoff),
+	)
+
 	// Initialize the retrypool with a single worker
 	c.pool = retrypool.New[*workItem](
 		context.Background(),
 		[]retrypool.Worker[*workItem]{c},
 		c.poolOptions...,
 	)
+	c.writer = newScheduler(c.pool, 1, c.burst)
+
+	if c.resultTTL > 0 {
+		c.stopSweeper = make(chan struct{})
+		go c.sweep(c.stopSweeper)
+	}
 
-	// Prepare migrations
+	// Prepare migrations, it also makes sure the file exists for the readers
 	if err := c.prepareMigration(); err != nil {
 		return nil, err
 	}
 
+	if c.readers > 0 {
+		if c.readDB, err = sql.Open(c.driver, fmt.Sprintf(readFileConn, c.path)); err != nil {
+			return nil, err
+		}
+
+		c.readDB.SetMaxOpenConns(c.readers)
+		c.readDB.SetMaxIdleConns(c.readers)
+
+		// One worker per reader connection
+		workers := make([]retrypool.Worker[*workItem], c.readers)
+		for i := range workers {
+			workers[i] = &readWorker{comfy: c}
+		}
+
+		c.readPool = retrypool.New[*workItem](
+			context.Background(),
+			workers,
+			c.poolOptions...,
+		)
+		c.reader = newScheduler(c.readPool, c.readers, c.burst)
+	}
+
+	if c.snapshotDir != "" && c.snapshotInterval > 0 {
+		c.stopSnapshots = make(chan struct{})
+		c.snapshotsDone = make(chan struct{})
+		go c.snapshotEvery(c.stopSnapshots, c.snapshotsDone)
+	}
+
 	return c, nil
 }
 
 // Implement the Worker interface from retrypool
 func (c *ComfyDB) Run(ctx context.Context, item *workItem) error {
+	// Take the items queued behind this one along within the same transaction
+	if c.groupCommit > 1 && groupable(item) {
+		if batch := c.writer.nextBatch(c.groupCommit-1, groupable); len(batch) > 0 {
+			c.execBatch(c.db, append([]*workItem{item}, batch...))
+			return nil
+		}
+	}
+	return c.runWorkItem(c.db, item)
+}
+
+// readWorker executes the read-only work on one of the reader connections.
+type readWorker struct {
+	comfy *ComfyDB
+}
+
+// Implement the Worker interface from retrypool
+func (r *readWorker) Run(ctx context.Context, item *workItem) error {
+	return r.comfy.runWorkItem(r.comfy.readDB, item)
+}
+
+// Execute the work item with the given database and deliver its result.
+func (c *ComfyDB) runWorkItem(db *sql.DB, item *workItem) error {
+	// Already failed by close while waiting for its retry
+	if item.sched != nil && !item.sched.retried(item) {
+		return nil
+	}
+
+	// Skip the work if the caller is no longer interested
+	if err := item.ctx.Err(); err != nil {
+		item.done(nil, err)
+		return nil
+	}
+
 	// Execute the function
-	res, err := item.fn(c.db)
+	res, err := c.call(db, item)
 
-	// Store the result
-	if err != nil {
-		item.result <- err
-	} else {
-		item.result <- res
+	// Give it back to the retrypool to be executed again, a panic is never retried
+	if err != nil && !errors.Is(err, ErrWorkPanic) && c.retryable(err) {
+		return err
 	}
-	close(item.result)
+
+	// Store the result
+	item.done(res, err)
 
 	return nil
 }
 
+// Execute the function of the work item.
+// A panic fails the work, the waiter and the scheduler must not be left hanging.
+func (c *ComfyDB) call(db *sql.DB, item *workItem) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if c.onPanic != nil {
+				c.onPanic(r, string(debug.Stack()))
+			}
+			value, err = nil, fmt.Errorf("%w %v: %v", ErrWorkPanic, item.id, r)
+		}
+	}()
+	return item.fn(item.ctx, db)
+}
+
+// Fire adds a new SQL function to be executed without keeping its result, there is nothing to wait for.
+func (c *ComfyDB) Fire(fn SqlFn) {
+	c.FireContext(context.Background(), func(ctx context.Context, db *sql.DB) (interface{}, error) {
+		return fn(db)
+	})
+}
+
+// FireContext adds a new SQL function to be executed with a context without keeping its result.
+func (c *ComfyDB) FireContext(ctx context.Context, fn SqlContextFn) {
+	c.dispatch(c.newWorkItem(ctx, fn))
+}
+
+// NewWithPriority adds a new SQL function to be executed in the lane of the priority.
+func (c *ComfyDB) NewWithPriority(priority Priority, fn SqlFn) uint64 {
+	return c.NewContext(ContextWithPriority(context.Background(), priority), func(ctx context.Context, db *sql.DB) (interface{}, error) {
+		return fn(db)
+	})
+}
+
 // New adds a new SQL function to be executed
 func (c *ComfyDB) New(fn SqlFn) uint64 {
+	return c.NewContext(context.Background(), func(ctx context.Context, db *sql.DB) (interface{}, error) {
+		return fn(db)
+	})
+}
+
+// NewContext adds a new SQL function to be executed with a context.
+// The work is skipped with the context error if the context is done when the worker picks it up.
+// The priority carried by the context (see ContextWithPriority) selects its lane.
+func (c *ComfyDB) NewContext(ctx context.Context, fn SqlContextFn) uint64 {
+	item := c.newWorkItem(ctx, fn)
+
+	// Store the work item
+	c.results.Store(item.id, item)
+
+	c.dispatch(item)
+
+	return item.id
+}
+
+// NewRead adds a new read-only SQL function to be executed by the readers.
+// Without WithReadPool it is executed by the writer like any other work.
+func (c *ComfyDB) NewRead(fn SqlFn) uint64 {
+	return c.NewReadContext(context.Background(), func(ctx context.Context, db *sql.DB) (interface{}, error) {
+		return fn(db)
+	})
+}
+
+// NewReadContext adds a new read-only SQL function to be executed by the readers with a context.
+func (c *ComfyDB) NewReadContext(ctx context.Context, fn SqlContextFn) uint64 {
+	item := c.newWorkItem(ctx, fn)
+
+	// Store the work item
+	c.results.Store(item.id, item)
+
+	c.dispatchRead(item)
+
+	return item.id
+}
+
+// Create a work item with the next workID.
+func (c *ComfyDB) newWorkItem(ctx context.Context, fn SqlContextFn) *workItem {
 
 	// Check if we're about to overflow and reset if necessary
 	if c.count.Load() == math.MaxUint64 {
 		c.count.Store(1) // Reset to 1
 	}
 
-	item := &workItem{
-		id:     c.count.Add(1),
-		fn:     fn,
-		result: make(chan interface{}, 1),
+	return &workItem{
+		id:       c.count.Add(1),
+		ctx:      ctx,
+		fn:       fn,
+		priority: PriorityFromContext(ctx),
+		group:    groupCommitFromContext(ctx),
+		result:   make(chan interface{}, 1),
 	}
+}
 
-	// Store the work item
-	c.results.Store(item.id, item)
+// Dispatch the work item to the writer.
+func (c *ComfyDB) dispatch(item *workItem) {
+	c.writer.push(item)
+}
 
-	// Dispatch the work item to the retrypool
-	err := c.pool.Submit(item)
-	if err != nil {
-		// Handle the error appropriately
-		// For now, let's panic
-		panic(fmt.Sprintf("Failed to dispatch work item: %v", err))
+// Dispatch the read-only work item to the readers, or to the writer without read pool.
+func (c *ComfyDB) dispatchRead(item *workItem) {
+	// Reads usually return open rows, they can't hold the transaction of a group commit
+	item.solo = true
+	if c.reader == nil {
+		c.dispatch(item)
+		return
 	}
-
-	return item.id
+	c.reader.push(item)
 }
 
 // WaitFor waits for the result of a workID (your query).
 func (c *ComfyDB) WaitFor(workID uint64) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res, err := c.WaitForContext(ctx, workID)
+	if err == context.DeadlineExceeded {
+		return nil, ErrWaitTimeout
+	}
+	return res, err
+}
+
+// WaitForContext waits for the result of a workID (your query) until the context is done.
+// The work stays registered when the context is done, so you can wait for it again.
+func (c *ComfyDB) WaitForContext(ctx context.Context, workID uint64) (interface{}, error) {
 	value, ok := c.results.Load(workID)
 	if !ok {
-		return nil, fmt.Errorf("workID not found")
+		return nil, ErrWorkNotFound
 	}
 	item := value.(*workItem)
 
@@ -287,8 +645,53 @@ func (c *ComfyDB) WaitFor(workID uint64) (interface{}, error) {
 		// Delete the item from the results map after consuming the result
 		c.results.Delete(workID)
 		return res, nil
-	case <-time.After(30 * time.Second):
-		return nil, fmt.Errorf("timeout waiting for result")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Pending returns the workIDs whose result wasn't claimed yet, queued, running or done.
+func (c *ComfyDB) Pending() []uint64 {
+	ids := []uint64{}
+	c.results.Range(func(key, value interface{}) bool {
+		ids = append(ids, key.(uint64))
+		return true
+	})
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// Forget drops the result of a workID, the work itself still gets executed.
+// Returns false when the workID wasn't pending.
+func (c *ComfyDB) Forget(workID uint64) bool {
+	_, ok := c.results.LoadAndDelete(workID)
+	return ok
+}
+
+// Remove the results done for longer than the TTL until stopped.
+func (c *ComfyDB) sweep(stop chan struct{}) {
+	interval := c.resultTTL / 2
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			deadline := now.Add(-c.resultTTL).UnixNano()
+			c.results.Range(func(key, value interface{}) bool {
+				completedAt := value.(*workItem).completedAt.Load()
+				if completedAt != 0 && completedAt < deadline {
+					c.results.Delete(key)
+				}
+				return true
+			})
+		}
 	}
 }
 
@@ -296,7 +699,8 @@ func (c *ComfyDB) WaitFor(workID uint64) (interface{}, error) {
 func (c *ComfyDB) WaitForChn(workID uint64) <-chan interface{} {
 	value, ok := c.results.Load(workID)
 	if !ok {
-		ch := make(chan interface{})
+		ch := make(chan interface{}, 1)
+		ch <- ErrWorkNotFound
 		close(ch)
 		return ch
 	}
@@ -316,232 +720,6 @@ func (c *ComfyDB) WaitForChn(workID uint64) <-chan interface{} {
 	return resultCh
 }
 
-// Migrate up all the available migrations.
-func (c *ComfyDB) Up(ctx context.Context) error {
-	if err := c.prepareMigration(); err != nil {
-		return err
-	}
-
-	index, err := c.Index()
-	if err != nil {
-		return err
-	}
-
-	migrationExists := map[uint]bool{}
-	for _, v := range index {
-		migrationExists[v] = true
-	}
-
-	localSorted := c.sort()
-
-	migrationUpID := c.New(func(db *sql.DB) (interface{}, error) {
-		tx, err := db.BeginTx(ctx, nil)
-		if err != nil {
-			return nil, err
-		}
-		defer tx.Rollback()
-		for _, migration := range localSorted {
-			if migration.Version == 0 || migration.Label == "" {
-				return nil, fmt.Errorf("invalid migration: version and label must be set")
-			}
-
-			if migration.Up == nil || migration.Down == nil {
-				return nil, fmt.Errorf("invalid migration: up and down must be set")
-			}
-
-			if migrationExists[migration.Version] {
-				continue
-			}
-
-			if err := migration.Up(tx); err != nil {
-				return nil, err
-			}
-
-			if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %v (version, description) VALUES (?, ?)", c.migrationTableName), migration.Version, migration.Label); err != nil {
-				return nil, fmt.Errorf("failed to insert migration (version=%v, description=%s): %w", migration.Version, migration.Label, err)
-			}
-		}
-		return nil, tx.Commit()
-	})
-	result, err := c.WaitFor(migrationUpID)
-	if err != nil {
-		return err
-	}
-	if errResult, ok := result.(error); ok {
-		return errResult
-	}
-	return nil
-}
-
-// Migrate down using the amount of iterations to rollback.
-func (c *ComfyDB) Down(ctx context.Context, amount int) error {
-	if err := c.prepareMigration(); err != nil {
-		return err
-	}
-
-	index, err := c.Index()
-	if err != nil {
-		return err
-	}
-
-	if len(index) == 0 {
-		return fmt.Errorf("no migrations to rollback")
-	}
-
-	if amount > len(index) {
-		amount = len(index)
-	}
-
-	migrationExists := map[uint]bool{}
-	for _, v := range index {
-		migrationExists[v] = true
-	}
-
-	localSorted := c.sort()
-
-	migrationDownID := c.New(func(db *sql.DB) (interface{}, error) {
-		tx, err := db.BeginTx(ctx, nil)
-		if err != nil {
-			return nil, err
-		}
-		defer tx.Rollback()
-		for i := len(index) - 1; i >= len(index)-amount; i-- {
-			migration := localSorted[index[i]-1]
-
-			if migration.Version == 0 || migration.Label == "" {
-				return nil, fmt.Errorf("invalid migration: version and label must be set")
-			}
-
-			if migration.Up == nil || migration.Down == nil {
-				return nil, fmt.Errorf("invalid migration: up and down must be set")
-			}
-
-			if !migrationExists[migration.Version] {
-				return nil, fmt.Errorf("migration (version=%v, label=%s) doesn't exist", migration.Version, migration.Label)
-			}
-
-			if err := migration.Down(tx); err != nil {
-				return nil, err
-			}
-
-			if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %v WHERE version = ?", c.migrationTableName), migration.Version); err != nil {
-				return nil, fmt.Errorf("failed to delete migration (version=%v, label=%s): %w", migration.Version, migration.Label, err)
-			}
-		}
-		return nil, tx.Commit()
-	})
-	result, err := c.WaitFor(migrationDownID)
-	if err != nil {
-		return err
-	}
-	if errResult, ok := result.(error); ok {
-		return errResult
-	}
-	return nil
-}
-
-// Get all versions of the migrations.
-func (c *ComfyDB) Index() ([]uint, error) {
-	currentIndexID := c.New(func(db *sql.DB) (interface{}, error) {
-		var versions []uint
-		rows, err := db.Query(fmt.Sprintf("SELECT version FROM %v ORDER BY version ASC", c.migrationTableName))
-		if err != nil {
-			return nil, err
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var version uint
-			if err := rows.Scan(&version); err != nil {
-				return nil, err
-			}
-			versions = append(versions, version)
-		}
-		return versions, nil
-	})
-	result, err := c.WaitFor(currentIndexID)
-	if err != nil {
-		return nil, err
-	}
-	switch value := result.(type) {
-	case []uint:
-		return value, nil
-	case error:
-		if value == sql.ErrNoRows {
-			return []uint{}, nil
-		}
-		return nil, value
-	default:
-		return nil, fmt.Errorf("unexpected type")
-	}
-}
-
-// Get all migrations.
-func (c *ComfyDB) Migrations() ([]Migration, error) {
-	migrationsID := c.New(func(db *sql.DB) (interface{}, error) {
-		var migrations []Migration
-		rows, err := db.Query(fmt.Sprintf("SELECT version, description FROM %v ORDER BY version ASC", c.migrationTableName))
-		if err != nil {
-			return nil, err
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var version uint
-			var description string
-			if err := rows.Scan(&version, &description); err != nil {
-				return nil, err
-			}
-			migrations = append(migrations, Migration{
-				Version: version,
-				Label:   description,
-			})
-		}
-		return migrations, nil
-	})
-	result, err := c.WaitFor(migrationsID)
-	if err != nil {
-		return nil, err
-	}
-	switch value := result.(type) {
-	case []Migration:
-		return value, nil
-	case error:
-		if value == sql.ErrNoRows {
-			return []Migration{}, nil
-		}
-		return nil, value
-	default:
-		return nil, fmt.Errorf("unexpected type")
-	}
-}
-
-// Get current version of the migrations.
-func (c *ComfyDB) Version() (uint, error) {
-	versionID := c.New(func(db *sql.DB) (interface{}, error) {
-		var version uint
-		row := db.QueryRow(fmt.Sprintf("SELECT version FROM %v ORDER BY version DESC LIMIT 1", c.migrationTableName))
-		err := row.Scan(&version)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return uint(0), nil
-			}
-			return nil, err
-		}
-		return version, nil
-	})
-	result, err := c.WaitFor(versionID)
-	if err != nil {
-		return 0, err
-	}
-	switch value := result.(type) {
-	case uint:
-		return value, nil
-	case error:
-		return 0, value
-	default:
-		return 0, fmt.Errorf("unexpected type")
-	}
-}
-
 // Properties of one column in a table.
 // Columns: cid name type notnull dflt_value pk
 type Column struct {
@@ -556,7 +734,7 @@ type Column struct {
 // Show all tables in the database.
 // Returns a slice of the names of the tables.
 func (c *ComfyDB) ShowTables() ([]string, error) {
-	tablesID := c.New(func(db *sql.DB) (interface{}, error) {
+	return SubmitRead(c, func(ctx context.Context, db *sql.DB) ([]string, error) {
 		rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table'")
 		if err != nil {
 			return nil, err
@@ -571,24 +749,12 @@ func (c *ComfyDB) ShowTables() ([]string, error) {
 			tables = append(tables, table)
 		}
 		return tables, nil
-	})
-	result, err := c.WaitFor(tablesID)
-	if err != nil {
-		return nil, err
-	}
-	switch value := result.(type) {
-	case []string:
-		return value, nil
-	case error:
-		return nil, value
-	default:
-		return nil, fmt.Errorf("unexpected type")
-	}
+	}).Get(context.Background())
 }
 
 // Show all columns in a table.
 func (c *ComfyDB) ShowColumns(table string) ([]Column, error) {
-	columnsID := c.New(func(db *sql.DB) (interface{}, error) {
+	return SubmitRead(c, func(ctx context.Context, db *sql.DB) ([]Column, error) {
 		rows, err := db.Query(fmt.Sprintf("PRAGMA table_info('%v')", table))
 		if err != nil {
 			return nil, err
@@ -604,19 +770,7 @@ func (c *ComfyDB) ShowColumns(table string) ([]Column, error) {
 			cols = append(cols, col)
 		}
 		return cols, nil
-	})
-	result, err := c.WaitFor(columnsID)
-	if err != nil {
-		return nil, err
-	}
-	switch value := result.(type) {
-	case []Column:
-		return value, nil
-	case error:
-		return nil, value
-	default:
-		return nil, fmt.Errorf("unexpected type")
-	}
+	}).Get(context.Background())
 }
 
 // RunSQL allows executing a custom SQL function and waits for its result.
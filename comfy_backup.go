@@ -0,0 +1,113 @@
+package comfylite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Amount of pages copied per work item by default while backing up
+const defaultBackupPagesPerStep = 100
+
+// BackupOptions changes how Backup copies the database.
+type BackupOptions struct {
+	// PagesPerStep is the amount of pages copied per work item, 100 when zero, everything at once when negative
+	PagesPerStep int
+	// Progress is called after each step with the pages left to copy and the total
+	Progress func(remaining, total int)
+}
+
+// Backup copies the database into the file at destPath with the sqlite backup API, the database can be a file or in memory.
+// The pages are copied in steps, each one a work item, so the other work goes on during the backup
+// and what it writes is part of the copy. The priority of the context applies to the steps.
+// The copy is written next to destPath then renamed, an existing file is only replaced once the copy is complete.
+func (c *ComfyDB) Backup(ctx context.Context, destPath string, opts BackupOptions) (err error) {
+	if opts.PagesPerStep == 0 {
+		opts.PagesPerStep = defaultBackupPagesPerStep
+	}
+
+	temporary := destPath + ".backup"
+	os.Remove(temporary)
+	defer func() {
+		if err != nil {
+			os.Remove(temporary)
+		}
+	}()
+
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(temporary)
+	if err != nil {
+		return err
+	}
+	dest := conn.(*sqlite3.SQLiteConn)
+	defer dest.Close()
+
+	var source *sqlite3.SQLiteConn
+	var backup *sqlite3.SQLiteBackup
+
+	// The backup is attached to the connection of the writer, it is finished in turn
+	finish := func() error {
+		_, err := submitSolo(context.Background(), c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+			return struct{}{}, backup.Finish()
+		}).Get(context.Background())
+		backup = nil
+		return err
+	}
+	defer func() {
+		if backup != nil {
+			finish()
+		}
+	}()
+
+	for done := false; !done; {
+		var remaining, total int
+		_, err := submitSolo(ctx, c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+			srcConn, err := db.Conn(ctx)
+			if err != nil {
+				return struct{}{}, err
+			}
+			defer srcConn.Close()
+
+			return struct{}{}, srcConn.Raw(func(src interface{}) error {
+				srcSQLite, ok := src.(*sqlite3.SQLiteConn)
+				if !ok {
+					return fmt.Errorf("backup requires the sqlite3 driver, got %T", src)
+				}
+				if backup == nil {
+					if backup, err = dest.Backup("main", srcSQLite, "main"); err != nil {
+						return err
+					}
+					source = srcSQLite
+				} else if source != srcSQLite {
+					return errors.New("the connection of the writer changed during the backup")
+				}
+
+				if done, err = backup.Step(opts.PagesPerStep); err != nil {
+					return err
+				}
+				remaining, total = backup.Remaining(), backup.PageCount()
+				return nil
+			})
+		}).Get(ctx)
+		if err != nil {
+			return err
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(remaining, total)
+		}
+	}
+
+	// Finish before the rename so the copy is complete
+	if err := finish(); err != nil {
+		return err
+	}
+	if err := dest.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, destPath)
+}
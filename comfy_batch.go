@@ -0,0 +1,153 @@
+package comfylite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Name of the savepoint wrapping each item of a batch
+const batchSavepoint = "comfy_batch"
+
+type groupCommitKey struct{}
+
+// ContextWithGroupCommit returns a context letting the work submitted with it join a group commit, see WithGroupCommit.
+// Only use it for work that neither begins a transaction, nor returns open rows, nor relies on PRAGMAs or retries.
+func ContextWithGroupCommit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, groupCommitKey{}, true)
+}
+
+// Whether the context lets the work join a group commit.
+func groupCommitFromContext(ctx context.Context) bool {
+	group, _ := ctx.Value(groupCommitKey{}).(bool)
+	return group
+}
+
+// SubmitBatch adds SQL functions to be executed together within a single transaction, it returns one workID per function.
+// Each function gets its own savepoint, a failing function only rolls back its own changes and the others are committed.
+// The functions must not open their own transaction nor return open rows.
+func (c *ComfyDB) SubmitBatch(fns ...SqlFn) []uint64 {
+	ids := make([]uint64, len(fns))
+	items := make([]*workItem, len(fns))
+	for i, fn := range fns {
+		fn := fn
+		items[i] = c.newWorkItem(context.Background(), func(ctx context.Context, db *sql.DB) (interface{}, error) {
+			return fn(db)
+		})
+		ids[i] = items[i].id
+
+		// Store the work item
+		c.results.Store(items[i].id, items[i])
+	}
+
+	// The batch travels through the scheduler as a single work item
+	carrier := c.newWorkItem(context.Background(), func(ctx context.Context, db *sql.DB) (interface{}, error) {
+		c.execBatch(db, items)
+		return nil, nil
+	})
+	carrier.solo = true
+	carrier.complete = func(_ interface{}, err error) {
+		// The batch never started, its items share the same fate
+		if err != nil {
+			for _, item := range items {
+				item.done(nil, err)
+			}
+		}
+	}
+	c.dispatch(carrier)
+
+	return ids
+}
+
+// Whether the work item can join the transaction of a group commit.
+func groupable(item *workItem) bool {
+	return item.group && !item.solo
+}
+
+// Execute the work items within a single transaction, each of them within its own savepoint.
+// The writer has a single connection, the statements of the functions are part of the transaction.
+// The outcomes are delivered once the transaction is over, a failed commit fails every item.
+// A work item ending the transaction fails the batch with ErrBatchAborted, the items after it aren't executed.
+func (c *ComfyDB) execBatch(db *sql.DB, items []*workItem) {
+	values := make([]interface{}, len(items))
+	errs := make([]error, len(items))
+
+	if _, err := db.Exec("BEGIN"); err != nil {
+		for _, item := range items {
+			item.done(nil, err)
+		}
+		return
+	}
+
+	for i, item := range items {
+		// Skip the work if the caller is no longer interested
+		if errs[i] = item.ctx.Err(); errs[i] != nil {
+			continue
+		}
+		if _, errs[i] = db.Exec("SAVEPOINT " + batchSavepoint); errs[i] != nil {
+			continue
+		}
+		values[i], errs[i] = c.call(db, item)
+		var err error
+		if errs[i] != nil {
+			values[i] = nil
+			_, err = db.Exec("ROLLBACK TO " + batchSavepoint)
+		}
+		if err == nil {
+			_, err = db.Exec("RELEASE " + batchSavepoint)
+		}
+		// INSERT OR ROLLBACK, RAISE(ROLLBACK) or an I/O error end the whole transaction
+		if err != nil || !inTransaction(db) {
+			abortBatch(db, items, i, values, errs)
+			return
+		}
+	}
+
+	if _, err := db.Exec("COMMIT"); err != nil {
+		db.Exec("ROLLBACK")
+		for i := range items {
+			if errs[i] == nil {
+				values[i], errs[i] = nil, err
+			}
+		}
+	}
+
+	for i, item := range items {
+		item.done(values[i], errs[i])
+	}
+}
+
+// Fail the work items of a batch whose transaction was ended by the work item at aborted.
+// The items before it were rolled back along with it, the items after it aren't executed.
+func abortBatch(db *sql.DB, items []*workItem, aborted int, values []interface{}, errs []error) {
+	// Nothing is left to roll back when sqlite already did
+	db.Exec("ROLLBACK")
+
+	cause := fmt.Errorf("%w by work item %d", ErrBatchAborted, items[aborted].id)
+	for i, item := range items {
+		if errs[i] == nil || i > aborted {
+			values[i], errs[i] = nil, cause
+		}
+		item.done(values[i], errs[i])
+	}
+}
+
+// Whether the connection of the writer is still within a transaction, assumed when the driver can't tell.
+func inTransaction(db *sql.DB) bool {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	inside := true
+	conn.Raw(func(driverConn interface{}) error {
+		if sqliteConn, ok := driverConn.(*sqlite3.SQLiteConn); ok {
+			inside = !sqliteConn.AutoCommit()
+		}
+		return nil
+	})
+	return inside
+}
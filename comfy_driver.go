@@ -29,22 +29,70 @@ func (cd *ComfyDriver) Driver() driver.Driver {
 type comfyConn struct {
 	comfy   *ComfyDB
 	connStr string
+	// tx is the transaction currently pinned on the worker for this connection, if any.
+	tx *comfyTx
+}
+
+// execQuerier is the subset of *sql.DB and *sql.Tx used by the driver statements.
+type execQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 }
 
 func (cc *comfyConn) Prepare(query string) (driver.Stmt, error) {
-	return &comfyStmt{comfy: cc.comfy, query: query}, nil
+	return &comfyStmt{conn: cc, query: query}, nil
 }
 
 func (cc *comfyConn) Close() error {
+	if cc.tx != nil {
+		return cc.tx.Rollback()
+	}
 	return nil
 }
 
 func (cc *comfyConn) Begin() (driver.Tx, error) {
-	return &comfyTx{comfy: cc.comfy}, nil
+	return cc.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx pins a real transaction on the worker, every statement of this connection is executed through it
+// until Commit or Rollback while the rest of the queue waits.
+func (cc *comfyConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if cc.tx != nil {
+		return nil, ErrTxInProgress
+	}
+	tx, err := cc.comfy.pinTx(ctx, &sql.TxOptions{
+		Isolation: sql.IsolationLevel(opts.Isolation),
+		ReadOnly:  opts.ReadOnly,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tx.conn = cc
+	cc.tx = tx
+	return tx, nil
+}
+
+// Execute a function either within the pinned transaction or as a new work item.
+// A solo function returns open rows, it can't join a group commit.
+func (cc *comfyConn) do(ctx context.Context, solo bool, fn func(eq execQuerier) (interface{}, error)) interface{} {
+	if cc.tx != nil {
+		return cc.tx.do(fn, false)
+	}
+	submit := SubmitContext[interface{}]
+	if solo {
+		submit = submitSolo[interface{}]
+	}
+	value, err := submit(ctx, cc.comfy, func(ctx context.Context, db *sql.DB) (interface{}, error) {
+		return fn(db)
+	}).Get(ctx)
+	if err != nil {
+		return err
+	}
+	return value
 }
 
 type comfyStmt struct {
-	comfy *ComfyDB
+	conn  *comfyConn
 	query string
 }
 
@@ -57,25 +105,47 @@ func (cs *comfyStmt) NumInput() int {
 }
 
 func (cs *comfyStmt) Exec(args []driver.Value) (driver.Result, error) {
-	id := cs.comfy.New(func(db *sql.DB) (interface{}, error) {
-		return db.Exec(cs.query, convertValues(args)...)
+	return cs.exec(context.Background(), convertValues(args))
+}
+
+func (cs *comfyStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return cs.exec(ctx, convertNamedValues(args))
+}
+
+func (cs *comfyStmt) exec(ctx context.Context, args []interface{}) (driver.Result, error) {
+	result := cs.conn.do(ctx, false, func(eq execQuerier) (interface{}, error) {
+		return eq.ExecContext(ctx, cs.query, args...)
 	})
-	result := <-cs.comfy.WaitForChn(id)
-	if err, ok := result.(error); ok {
-		return nil, err
+	switch data := result.(type) {
+	case sql.Result:
+		return data, nil
+	case error:
+		return nil, data
+	default:
+		return nil, ErrUnexpectedResult
 	}
-	return result.(sql.Result), nil
 }
 
 func (cs *comfyStmt) Query(args []driver.Value) (driver.Rows, error) {
-	id := cs.comfy.New(func(db *sql.DB) (interface{}, error) {
-		return db.Query(cs.query, convertValues(args)...)
+	return cs.queryRows(context.Background(), convertValues(args))
+}
+
+func (cs *comfyStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return cs.queryRows(ctx, convertNamedValues(args))
+}
+
+func (cs *comfyStmt) queryRows(ctx context.Context, args []interface{}) (driver.Rows, error) {
+	result := cs.conn.do(ctx, true, func(eq execQuerier) (interface{}, error) {
+		return eq.QueryContext(ctx, cs.query, args...)
 	})
-	result := <-cs.comfy.WaitForChn(id)
-	if err, ok := result.(error); ok {
-		return nil, err
+	switch data := result.(type) {
+	case *sql.Rows:
+		return &comfyRows{rows: data}, nil
+	case error:
+		return nil, data
+	default:
+		return nil, ErrUnexpectedResult
 	}
-	return &comfyRows{rows: result.(*sql.Rows)}, nil
 }
 
 type comfyRows struct {
@@ -123,16 +193,96 @@ func (cr *comfyRows) Next(dest []driver.Value) error {
 	return nil
 }
 
+type txOp struct {
+	fn     func(eq execQuerier) (interface{}, error)
+	final  bool
+	result chan interface{}
+}
+
+// comfyTx is a transaction pinned on the worker, it holds the worker until Commit or Rollback.
 type comfyTx struct {
-	comfy *ComfyDB
+	comfy  *ComfyDB
+	conn   *comfyConn
+	pinned *Future[struct{}]
+	ops    chan txOp
 }
 
-func (ct *comfyTx) Commit() error {
+// Start a transaction within a work item that keeps executing the operations sent to it until the final one.
+func (c *ComfyDB) pinTx(ctx context.Context, opts *sql.TxOptions) (*comfyTx, error) {
+	ct := &comfyTx{
+		comfy: c,
+		ops:   make(chan txOp),
+	}
+	ready := make(chan struct{})
+
+	ct.pinned = submitSolo(ctx, c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		tx, err := db.BeginTx(ctx, opts)
+		if err != nil {
+			return struct{}{}, err
+		}
+		close(ready)
+		for op := range ct.ops {
+			res, err := op.fn(tx)
+			if err != nil {
+				op.result <- err
+			} else {
+				op.result <- res
+			}
+			close(op.result)
+			if op.final {
+				return struct{}{}, nil
+			}
+		}
+		return struct{}{}, tx.Rollback()
+	})
+
+	select {
+	case <-ready:
+		return ct, nil
+	case <-ct.pinned.Done():
+		_, err := ct.pinned.Get(context.Background())
+		return nil, err
+	}
+}
+
+// Send an operation to the pinned transaction and wait for its result.
+func (ct *comfyTx) do(fn func(eq execQuerier) (interface{}, error), final bool) interface{} {
+	op := txOp{
+		fn:     fn,
+		final:  final,
+		result: make(chan interface{}, 1),
+	}
+	ct.ops <- op
+	return <-op.result
+}
+
+// Send the last operation, release the worker and the connection.
+func (ct *comfyTx) end(fn func(tx *sql.Tx) error) error {
+	if ct.conn == nil || ct.conn.tx != ct {
+		return sql.ErrTxDone
+	}
+	result := ct.do(func(eq execQuerier) (interface{}, error) {
+		return nil, fn(eq.(*sql.Tx))
+	}, true)
+	close(ct.ops)
+	ct.pinned.Get(context.Background())
+	ct.conn.tx = nil
+	if err, ok := result.(error); ok {
+		return err
+	}
 	return nil
 }
 
+func (ct *comfyTx) Commit() error {
+	return ct.end(func(tx *sql.Tx) error {
+		return tx.Commit()
+	})
+}
+
 func (ct *comfyTx) Rollback() error {
-	return nil
+	return ct.end(func(tx *sql.Tx) error {
+		return tx.Rollback()
+	})
 }
 
 func convertValues(vals []driver.Value) []interface{} {
@@ -143,6 +293,18 @@ func convertValues(vals []driver.Value) []interface{} {
 	return result
 }
 
+func convertNamedValues(vals []driver.NamedValue) []interface{} {
+	result := make([]interface{}, len(vals))
+	for i, v := range vals {
+		if v.Name != "" {
+			result[i] = sql.Named(v.Name, v.Value)
+		} else {
+			result[i] = v.Value
+		}
+	}
+	return result
+}
+
 type OpenDBOptions struct {
 	options         []string
 	withForeignKeys bool
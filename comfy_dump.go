@@ -0,0 +1,359 @@
+package comfylite3
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Amount of rows read per work item by default while dumping
+const defaultDumpChunkSize = 500
+
+// DumpOptions changes what Dump writes.
+type DumpOptions struct {
+	// Data adds the rows of the tables as INSERT statements
+	Data bool
+	// ChunkSize is the amount of rows read per work item, 500 when zero
+	ChunkSize int
+}
+
+// Table written by the dump.
+type dumpTable struct {
+	name         string
+	sql          string
+	withoutRowID bool
+	virtual      bool
+	columns      []TableColumn
+}
+
+// Module of a virtual table created by fts
+var ftsModule = regexp.MustCompile(`(?is)^CREATE\s+VIRTUAL\s+TABLE\s+.*?\bUSING\s+fts[345]\s*\(`)
+
+// Option of a fts table storing its content in another table, or nowhere when empty
+var ftsContentOption = regexp.MustCompile(`(?i)\bcontent\s*=\s*(?:'((?:[^']|'')*)'|"((?:[^"]|"")*)"|([^\s,)]+))`)
+
+// Whether the virtual table is a fts table with its content in another table, and whether it keeps no content at all.
+func ftsContent(createSQL string) (external, contentless bool) {
+	if !ftsModule.MatchString(createSQL) {
+		return false, false
+	}
+	option := ftsContentOption.FindStringSubmatch(createSQL)
+	if option == nil {
+		return false, false
+	}
+	content := option[1] + option[2] + option[3]
+	return content != "", content == ""
+}
+
+// Dump writes the schema, and the rows with DumpOptions.Data, as SQL statements that Load executes, like the .dump of the sqlite3 command.
+// The rows are read in chunks, each one a work item, so the other work goes on during the dump.
+// The dump isn't a snapshot: what is written meanwhile may or may not be part of it, see Backup for a consistent copy.
+// The rows of a virtual table are inserted through it, with their rowid, and its shadow tables are left out.
+// A fts table with its content in another table is rebuilt from it instead, a contentless one fails the dump with Data.
+func (c *ComfyDB) Dump(ctx context.Context, w io.Writer, opts DumpOptions) error {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultDumpChunkSize
+	}
+
+	tables, others, err := c.dumpSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The fts tables with their content elsewhere are rebuilt once every table is filled
+	rebuilds := []string{}
+	for _, table := range tables {
+		if !opts.Data || !table.virtual {
+			continue
+		}
+		switch external, contentless := ftsContent(table.sql); {
+		case contentless:
+			return fmt.Errorf("the rows of the contentless fts table %s can't be dumped", table.name)
+		case external:
+			rebuilds = append(rebuilds, fmt.Sprintf("INSERT INTO %s(%s) VALUES('rebuild');\n", quoteIdentifier(table.name), quoteIdentifier(table.name)))
+		}
+	}
+
+	if _, err := io.WriteString(w, "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n"); err != nil {
+		return err
+	}
+
+	var sequence *dumpTable
+	for i := range tables {
+		table := &tables[i]
+		if table.name == "sqlite_sequence" {
+			sequence = table
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s;\n", table.sql); err != nil {
+			return err
+		}
+		if external, _ := ftsContent(table.sql); opts.Data && !external {
+			if err := c.dumpRows(ctx, w, *table, opts.ChunkSize); err != nil {
+				return err
+			}
+		}
+	}
+
+	// sqlite_sequence exists once a table with AUTOINCREMENT is created
+	if opts.Data && sequence != nil {
+		if _, err := io.WriteString(w, "DELETE FROM sqlite_sequence;\n"); err != nil {
+			return err
+		}
+		if err := c.dumpRows(ctx, w, *sequence, opts.ChunkSize); err != nil {
+			return err
+		}
+	}
+
+	for _, statement := range rebuilds {
+		if _, err := io.WriteString(w, statement); err != nil {
+			return err
+		}
+	}
+
+	for _, statement := range others {
+		if _, err := fmt.Fprintf(w, "%s;\n", statement); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "COMMIT;\n")
+	return err
+}
+
+// Read the tables to dump, then the indexes, views and triggers, in their creation order.
+func (c *ComfyDB) dumpSchema(ctx context.Context) ([]dumpTable, []string, error) {
+	type schema struct {
+		tables []dumpTable
+		others []string
+	}
+
+	result, err := SubmitReadContext(ctx, c, func(ctx context.Context, db *sql.DB) (schema, error) {
+		var s schema
+		// The shadow tables of a virtual table, like the ones of fts, are created along with it
+		rows, err := db.QueryContext(ctx, `
+		SELECT m.type, m.name, m.sql FROM sqlite_master m
+		LEFT JOIN pragma_table_list l ON l.schema = 'main' AND l.name = m.name
+		WHERE m.sql IS NOT NULL AND (m.name = 'sqlite_sequence' OR m.name NOT LIKE 'sqlite_%') AND m.name != ?
+		AND COALESCE(l.type, '') != 'shadow'
+		ORDER BY CASE m.type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, m.rowid`,
+			c.migrationLockTable())
+		if err != nil {
+			return s, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var kind, name, statement string
+			if err := rows.Scan(&kind, &name, &statement); err != nil {
+				return s, err
+			}
+			if kind == "table" {
+				s.tables = append(s.tables, dumpTable{name: name, sql: statement, virtual: strings.HasPrefix(statement, "CREATE VIRTUAL TABLE")})
+			} else {
+				s.others = append(s.others, statement)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return s, err
+		}
+		rows.Close()
+
+		for i := range s.tables {
+			table := &s.tables[i]
+			err := db.QueryRowContext(ctx, "SELECT wr FROM pragma_table_list(?) WHERE schema = 'main'", table.name).Scan(&table.withoutRowID)
+			if err != nil && err != sql.ErrNoRows {
+				return s, err
+			}
+			columns, err := readColumns(ctx, db, table.name)
+			if err != nil {
+				return s, err
+			}
+			// The generated and hidden columns can't be inserted
+			for _, col := range columns {
+				if col.Generated == "" && !col.Hidden {
+					table.columns = append(table.columns, col)
+				}
+			}
+		}
+		return s, nil
+	}).Get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.tables, result.others, nil
+}
+
+// Write the rows of a table as INSERT statements, one chunk per work item.
+func (c *ComfyDB) dumpRows(ctx context.Context, w io.Writer, table dumpTable, chunkSize int) error {
+	names := make([]string, len(table.columns))
+	// The unary plus hides the declared types, the driver would parse the dates and booleans otherwise
+	selected := make([]string, len(table.columns))
+	pk := []string{}
+	for i, col := range table.columns {
+		names[i] = quoteIdentifier(col.Name)
+		selected[i] = "+" + names[i]
+		if col.Pk {
+			pk = append(pk, names[i])
+		}
+	}
+	columns := strings.Join(selected, ",")
+	inserted := strings.Join(names, ",")
+	// The rowid of a virtual table isn't a column, the one of a fts table is the docid of its rows
+	if table.virtual {
+		inserted = "rowid," + inserted
+	}
+	insert := fmt.Sprintf("INSERT INTO %s(%s) VALUES(", quoteIdentifier(table.name), inserted)
+
+	type chunk struct {
+		statements []string
+		lastRowID  int64
+	}
+
+	var lastRowID int64 = math.MinInt64
+	for offset := 0; ; offset += chunkSize {
+		result, err := SubmitReadContext(ctx, c, func(ctx context.Context, db *sql.DB) (chunk, error) {
+			// Without a rowid the rows are paged in the order of the primary key
+			var rows *sql.Rows
+			var err error
+			if table.withoutRowID {
+				rows, err = db.QueryContext(ctx,
+					fmt.Sprintf("SELECT %s FROM %s ORDER BY %s LIMIT ? OFFSET ?", columns, quoteIdentifier(table.name), strings.Join(pk, ",")),
+					chunkSize, offset)
+			} else {
+				rows, err = db.QueryContext(ctx,
+					fmt.Sprintf("SELECT rowid, %s FROM %s WHERE rowid > ? ORDER BY rowid LIMIT ?", columns, quoteIdentifier(table.name)),
+					lastRowID, chunkSize)
+			}
+			if err != nil {
+				return chunk{}, err
+			}
+			defer rows.Close()
+
+			result := chunk{statements: []string{}, lastRowID: lastRowID}
+			for rows.Next() {
+				values := make([]interface{}, len(table.columns))
+				dest := make([]interface{}, 0, len(values)+1)
+				if !table.withoutRowID {
+					dest = append(dest, &result.lastRowID)
+				}
+				for i := range values {
+					dest = append(dest, &values[i])
+				}
+				if err := rows.Scan(dest...); err != nil {
+					return chunk{}, err
+				}
+
+				literals := make([]string, 0, len(values)+1)
+				if table.virtual {
+					literals = append(literals, strconv.FormatInt(result.lastRowID, 10))
+				}
+				for _, value := range values {
+					literals = append(literals, sqlLiteral(value))
+				}
+				result.statements = append(result.statements, insert+strings.Join(literals, ",")+");\n")
+			}
+			return result, rows.Err()
+		}).Get(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, statement := range result.statements {
+			if _, err := io.WriteString(w, statement); err != nil {
+				return err
+			}
+		}
+		if len(result.statements) < chunkSize {
+			return nil
+		}
+		lastRowID = result.lastRowID
+	}
+}
+
+// Write a value read from sqlite as a SQL literal.
+func sqlLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "1e999"
+		case math.IsInf(v, -1):
+			return "-1e999"
+		}
+		literal := strconv.FormatFloat(v, 'g', -1, 64)
+		// Keep the value a REAL once loaded
+		if !strings.ContainsAny(literal, ".e") {
+			literal += ".0"
+		}
+		return literal
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	default:
+		return "'" + strings.ReplaceAll(fmt.Sprint(v), "'", "''") + "'"
+	}
+}
+
+// Load executes a dump written by Dump, the database must be empty: no table of its own and no applied migration.
+// The dump is executed at once by the writer, in the transaction it holds.
+func (c *ComfyDB) Load(ctx context.Context, r io.Reader) error {
+	dump, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = submitSolo(ctx, c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		var tables, applied int
+		if err := db.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT IN (?, ?)",
+			c.migrationTableName, c.migrationLockTable()).Scan(&tables); err != nil {
+			return struct{}{}, err
+		}
+		if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %v", c.migrationTableName)).Scan(&applied); err != nil {
+			return struct{}{}, err
+		}
+		if tables > 0 || applied > 0 {
+			return struct{}{}, fmt.Errorf("%w: %d tables and %d applied migrations", ErrDatabaseNotEmpty, tables, applied)
+		}
+
+		var foreignKeys bool
+		if err := db.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+			return struct{}{}, err
+		}
+		defer func() {
+			if foreignKeys {
+				db.Exec("PRAGMA foreign_keys = ON")
+			}
+		}()
+
+		// The dump creates the migration table it had
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE %v", c.migrationTableName)); err != nil {
+			return struct{}{}, err
+		}
+
+		if _, err := db.ExecContext(ctx, string(dump)); err != nil {
+			// The single connection is left without the transaction of the dump
+			db.Exec("ROLLBACK")
+			return struct{}{}, err
+		}
+		return struct{}{}, nil
+	}).Get(ctx)
+
+	// Create or upgrade the migration table, whatever the dump had
+	if prepareErr := c.prepareMigration(); err == nil {
+		err = prepareErr
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package comfylite3
+
+import "errors"
+
+var (
+	// ErrClosed is returned for the work submitted after Close or still queued when it happened.
+	ErrClosed = errors.New("comfylite3 is closed")
+	// ErrWorkNotFound is returned when waiting for a workID that doesn't exist or was already consumed.
+	ErrWorkNotFound = errors.New("workID not found")
+	// ErrWaitTimeout is returned by WaitFor when the result didn't come in time.
+	ErrWaitTimeout = errors.New("timeout waiting for result")
+	// ErrUnexpectedResult is returned when a work item returned a value of an unexpected type.
+	ErrUnexpectedResult = errors.New("unexpected result type")
+	// ErrWorkPanic is returned when a work item panicked.
+	ErrWorkPanic = errors.New("panic in work item")
+	// ErrTxInProgress is returned when beginning a transaction on a connection that already has one.
+	ErrTxInProgress = errors.New("transaction already in progress")
+	// ErrInvalidMigration is returned for a migration missing its version, label, up or down.
+	ErrInvalidMigration = errors.New("invalid migration")
+	// ErrNoMigrations is returned when there is no applied migration to roll back.
+	ErrNoMigrations = errors.New("no migrations to rollback")
+	// ErrMigrationNotFound is returned when an applied migration isn't registered or when migrating to an unknown version.
+	ErrMigrationNotFound = errors.New("migration doesn't exist")
+	// ErrMigrationDrift is returned when an applied migration changed since, see MigrationDrift.
+	ErrMigrationDrift = errors.New("migration changed since it was applied")
+	// ErrMigrationInProgress is returned when another process holds the migration lock, see WithMigrationLock.
+	ErrMigrationInProgress = errors.New("migration in progress")
+	// ErrMigrationAborted is returned when a migration hook aborted the migrations, see MigrationHooks.
+	ErrMigrationAborted = errors.New("migration aborted")
+	// ErrDatabaseNotEmpty is returned by Load when the database already has tables or applied migrations.
+	ErrDatabaseNotEmpty = errors.New("database is not empty")
+	// ErrSnapshotsDisabled is returned by the snapshot functions when WithSnapshots isn't set.
+	ErrSnapshotsDisabled = errors.New("snapshots are disabled")
+	// ErrSnapshotCorrupt is returned when a snapshot fails PRAGMA integrity_check.
+	ErrSnapshotCorrupt = errors.New("snapshot is corrupt")
+	// ErrBatchAborted is returned for the work of a batch or group commit rolled back by another work item ending the transaction.
+	ErrBatchAborted = errors.New("batch transaction aborted")
+)
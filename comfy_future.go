@@ -0,0 +1,83 @@
+package comfylite3
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Future is the typed result of a function submitted with Submit or SubmitContext.
+// The value and the error are kept apart, a function can return an error as its value.
+type Future[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// Submit a typed function to be executed by the scheduler.
+func Submit[T any](c *ComfyDB, fn func(ctx context.Context, db *sql.DB) (T, error)) *Future[T] {
+	return SubmitContext(context.Background(), c, fn)
+}
+
+// SubmitContext submits a typed function to be executed by the scheduler with a context.
+// The work is skipped with the context error if the context is done when the worker picks it up.
+func SubmitContext[T any](ctx context.Context, c *ComfyDB, fn func(ctx context.Context, db *sql.DB) (T, error)) *Future[T] {
+	f, item := newFuture(ctx, c, fn)
+	c.dispatch(item)
+	return f
+}
+
+// Submit a typed read-only function to be executed by the readers.
+func SubmitRead[T any](c *ComfyDB, fn func(ctx context.Context, db *sql.DB) (T, error)) *Future[T] {
+	return SubmitReadContext(context.Background(), c, fn)
+}
+
+// SubmitReadContext submits a typed read-only function to be executed by the readers with a context.
+// Without WithReadPool it is executed by the writer like any other work.
+func SubmitReadContext[T any](ctx context.Context, c *ComfyDB, fn func(ctx context.Context, db *sql.DB) (T, error)) *Future[T] {
+	f, item := newFuture(ctx, c, fn)
+	c.dispatchRead(item)
+	return f
+}
+
+// Submit a typed function that never joins a group commit, for the work managing its own transaction or returning open rows.
+func submitSolo[T any](ctx context.Context, c *ComfyDB, fn func(ctx context.Context, db *sql.DB) (T, error)) *Future[T] {
+	f, item := newFuture(ctx, c, fn)
+	item.solo = true
+	c.dispatch(item)
+	return f
+}
+
+// Create the future and the work item that completes it.
+func newFuture[T any](ctx context.Context, c *ComfyDB, fn func(ctx context.Context, db *sql.DB) (T, error)) (*Future[T], *workItem) {
+	f := &Future[T]{
+		done: make(chan struct{}),
+	}
+
+	item := c.newWorkItem(ctx, func(ctx context.Context, db *sql.DB) (interface{}, error) {
+		value, err := fn(ctx, db)
+		f.value = value
+		return nil, err
+	})
+	item.complete = func(_ interface{}, err error) {
+		f.err = err
+		close(f.done)
+	}
+
+	return f, item
+}
+
+// Done is closed when the result is available.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get waits for the result until the context is done, it can be called as many times as you want.
+func (f *Future[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
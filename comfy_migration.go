@@ -0,0 +1,687 @@
+package comfylite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Migration struct {
+	Version uint
+	Label   string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+	// Checksum is recorded when the migration is applied to detect a later change, it is the content hash for SQL files
+	Checksum string
+}
+
+// Create a new migration with a version, label, and up and down functions.
+func NewMigration(version uint, label string, up, down func(tx *sql.Tx) error) Migration {
+	return Migration{
+		Version: version,
+		Label:   label,
+		Up:      up,
+		Down:    down,
+	}
+}
+
+// Columns added to the migration table over time, the older tables get them when prepared.
+var migrationColumns = []struct {
+	name       string
+	definition string
+}{
+	{name: "checksum", definition: "TEXT"},
+	{name: "applied_at", definition: "DATETIME"},
+	// nanoseconds
+	{name: "duration", definition: "INTEGER"},
+}
+
+// Prepare the eventual creation of the migration table, and its upgrade.
+func (c *ComfyDB) prepareMigration() error {
+	_, err := Submit(c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %v (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			version INTEGER UNIQUE NOT NULL,
+			description VARCHAR(255) UNIQUE NOT NULL
+		)`, c.migrationTableName)); err != nil {
+			return struct{}{}, err
+		}
+
+		rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%v')", c.migrationTableName))
+		if err != nil {
+			return struct{}{}, err
+		}
+		existing := map[string]bool{}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return struct{}{}, err
+			}
+			existing[name] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return struct{}{}, err
+		}
+
+		for _, column := range migrationColumns {
+			if existing[column.name] {
+				continue
+			}
+			if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", c.migrationTableName, column.name, column.definition)); err != nil {
+				return struct{}{}, err
+			}
+		}
+		return struct{}{}, nil
+	}).Get(context.Background())
+	return err
+}
+
+// Row of the migration table.
+type appliedMigration struct {
+	Migration
+	appliedAt time.Time
+	duration  time.Duration
+}
+
+// Read the migration table, the rows recorded before the checksums have none.
+func (c *ComfyDB) applied(ctx context.Context) ([]appliedMigration, error) {
+	return SubmitReadContext(ctx, c, func(ctx context.Context, db *sql.DB) ([]appliedMigration, error) {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT version, description, checksum, applied_at, duration FROM %v ORDER BY version ASC", c.migrationTableName))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		migrations := []appliedMigration{}
+		for rows.Next() {
+			var migration appliedMigration
+			var checksum sql.NullString
+			var appliedAt sql.NullTime
+			var duration sql.NullInt64
+			if err := rows.Scan(&migration.Version, &migration.Label, &checksum, &appliedAt, &duration); err != nil {
+				return nil, err
+			}
+			migration.Checksum = checksum.String
+			migration.appliedAt = appliedAt.Time
+			migration.duration = time.Duration(duration.Int64)
+			migrations = append(migrations, migration)
+		}
+		return migrations, rows.Err()
+	}).Get(ctx)
+}
+
+// MigrationDrift describes an applied migration that changed since, its label or its checksum differs from the registered one.
+type MigrationDrift struct {
+	// Migration is the registered migration
+	Migration Migration
+	// Label and Checksum are the ones recorded when it was applied
+	Label    string
+	Checksum string
+}
+
+func (d MigrationDrift) Error() string {
+	if d.Label != d.Migration.Label {
+		return fmt.Sprintf("%v: version %v was applied as %q but is registered as %q", ErrMigrationDrift, d.Migration.Version, d.Label, d.Migration.Label)
+	}
+	return fmt.Sprintf("%v: version %v %q was applied with checksum %s but is registered with %s", ErrMigrationDrift, d.Migration.Version, d.Label, d.Checksum, d.Migration.Checksum)
+}
+
+func (d MigrationDrift) Unwrap() error {
+	return ErrMigrationDrift
+}
+
+// Compare the applied migrations with the registered ones.
+// Each drift is given to the drift handler when there is one, otherwise they are returned.
+func (c *ComfyDB) checkDrift(ctx context.Context) error {
+	applied, err := c.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	registered := c.registered()
+
+	drifts := []error{}
+	for _, row := range applied {
+		migration, ok := registered[row.Version]
+		if !ok {
+			continue
+		}
+		// Nothing to compare when either side has no checksum
+		checksumDrift := row.Checksum != "" && migration.Checksum != "" && row.Checksum != migration.Checksum
+		if row.Label == migration.Label && !checksumDrift {
+			continue
+		}
+		drift := MigrationDrift{Migration: migration, Label: row.Label, Checksum: row.Checksum}
+		if c.onMigrationDrift != nil {
+			c.onMigrationDrift(drift)
+			continue
+		}
+		drifts = append(drifts, drift)
+	}
+
+	return errors.Join(drifts...)
+}
+
+// Sort the migrations by version.
+func (c *ComfyDB) sort() []Migration {
+	cp := make([]Migration, len(c.migrations))
+	copy(cp, c.migrations)
+	sort.Slice(cp, func(i, j int) bool {
+		return cp[i].Version < cp[j].Version
+	})
+	return cp
+}
+
+// MigrationDirection tells whether a migration is applied or rolled back.
+type MigrationDirection int
+
+const (
+	MigrationUp MigrationDirection = iota
+	MigrationDown
+)
+
+func (d MigrationDirection) String() string {
+	if d == MigrationDown {
+		return "down"
+	}
+	return "up"
+}
+
+// MigrationError is returned when a migration fails, it names the failing migration.
+type MigrationError struct {
+	Migration Migration
+	Direction MigrationDirection
+	Err       error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migration %v %q %v failed: %v", e.Migration.Version, e.Migration.Label, e.Direction, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// One migration to apply or roll back.
+type migrationStep struct {
+	migration Migration
+	direction MigrationDirection
+	// covers holds the registered migrations recorded as applied along with a baseline
+	covers []Migration
+}
+
+// Registered migrations by version.
+type migrationsByVersion map[uint]Migration
+
+// Index the registered migrations by version, versions don't have to be contiguous.
+func (c *ComfyDB) registered() migrationsByVersion {
+	registered := migrationsByVersion{}
+	for _, migration := range c.migrations {
+		registered[migration.Version] = migration
+	}
+	return registered
+}
+
+// Resolve the registered migration of an applied version.
+func (m migrationsByVersion) applied(version uint) (Migration, error) {
+	migration, ok := m[version]
+	if !ok {
+		return Migration{}, fmt.Errorf("%w: version %v is applied but no migration is registered for it", ErrMigrationNotFound, version)
+	}
+	return migration, nil
+}
+
+// Check that the migration has everything it needs.
+func (m Migration) validate() error {
+	if m.Version == 0 || m.Label == "" {
+		return fmt.Errorf("%w: version and label must be set", ErrInvalidMigration)
+	}
+	if m.Up == nil || m.Down == nil {
+		return fmt.Errorf("%w: up and down must be set", ErrInvalidMigration)
+	}
+	return nil
+}
+
+// Check every registered migration and the baseline.
+func (c *ComfyDB) validateMigrations() error {
+	for _, migration := range c.migrations {
+		if err := migration.validate(); err != nil {
+			return err
+		}
+	}
+	return c.validateBaseline()
+}
+
+// Migrate up all the available migrations.
+func (c *ComfyDB) Up(ctx context.Context) error {
+	return c.withMigrationLock(ctx, func() error {
+		steps, err := c.planUp(ctx)
+		if err != nil {
+			return err
+		}
+		return c.migrate(ctx, steps)
+	})
+}
+
+// Migrate down using the amount of iterations to rollback.
+func (c *ComfyDB) Down(ctx context.Context, amount int) error {
+	return c.withMigrationLock(ctx, func() error {
+		steps, err := c.planDown(ctx, amount)
+		if err != nil {
+			return err
+		}
+		return c.migrate(ctx, steps)
+	})
+}
+
+// MigrateTo applies or rolls back the migrations until the given version is the last one applied.
+// The applied migrations above the version are rolled back, the pending ones up to it are applied.
+// Version zero rolls back everything.
+func (c *ComfyDB) MigrateTo(ctx context.Context, version uint) error {
+	return c.withMigrationLock(ctx, func() error {
+		steps, err := c.planTo(ctx, version)
+		if err != nil {
+			return err
+		}
+		return c.migrate(ctx, steps)
+	})
+}
+
+// Steps applying every pending migration.
+func (c *ComfyDB) planUp(ctx context.Context) ([]migrationStep, error) {
+	if err := c.prepareMigration(); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateMigrations(); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkDrift(ctx); err != nil {
+		return nil, err
+	}
+
+	index, err := c.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	migrationExists := map[uint]bool{}
+	for _, v := range index {
+		migrationExists[v] = true
+	}
+
+	steps := []migrationStep{}
+	if step, ok := c.baselineStep(index, ^uint(0)); ok {
+		steps = append(steps, step)
+		for _, migration := range step.covers {
+			migrationExists[migration.Version] = true
+		}
+	}
+
+	for _, migration := range c.sort() {
+		if !migrationExists[migration.Version] {
+			steps = append(steps, migrationStep{migration: migration, direction: MigrationUp})
+		}
+	}
+
+	return steps, nil
+}
+
+// Steps rolling back the last applied migrations.
+func (c *ComfyDB) planDown(ctx context.Context, amount int) ([]migrationStep, error) {
+	if err := c.prepareMigration(); err != nil {
+		return nil, err
+	}
+
+	index, err := c.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(index) == 0 {
+		return nil, ErrNoMigrations
+	}
+
+	if amount > len(index) {
+		amount = len(index)
+	}
+
+	registered := c.registered()
+
+	steps := []migrationStep{}
+	for i := len(index) - 1; i >= len(index)-amount; i-- {
+		migration, err := registered.applied(index[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if err := migration.validate(); err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, migrationStep{migration: migration, direction: MigrationDown})
+	}
+
+	return steps, nil
+}
+
+// Steps going up or down to the version.
+func (c *ComfyDB) planTo(ctx context.Context, version uint) ([]migrationStep, error) {
+	if err := c.prepareMigration(); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateMigrations(); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkDrift(ctx); err != nil {
+		return nil, err
+	}
+
+	index, err := c.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	registered := c.registered()
+
+	if _, ok := registered[version]; !ok && version != 0 && (c.baseline == nil || c.baseline.Version != version) {
+		return nil, fmt.Errorf("%w (version=%v)", ErrMigrationNotFound, version)
+	}
+
+	steps := []migrationStep{}
+	migrationExists := map[uint]bool{}
+	if step, ok := c.baselineStep(index, version); ok {
+		steps = append(steps, step)
+		for _, migration := range step.covers {
+			migrationExists[migration.Version] = true
+		}
+	}
+
+	for i := len(index) - 1; i >= 0; i-- {
+		migrationExists[index[i]] = true
+		if index[i] <= version {
+			continue
+		}
+		migration, err := registered.applied(index[i])
+		if err != nil {
+			return nil, err
+		}
+		if err := migration.validate(); err != nil {
+			return nil, err
+		}
+		steps = append(steps, migrationStep{migration: migration, direction: MigrationDown})
+	}
+
+	for _, migration := range c.sort() {
+		if migration.Version <= version && !migrationExists[migration.Version] {
+			steps = append(steps, migrationStep{migration: migration, direction: MigrationUp})
+		}
+	}
+
+	return steps, nil
+}
+
+// Execute the steps in order, within a single transaction or one transaction per migration.
+func (c *ComfyDB) migrate(ctx context.Context, steps []migrationStep) error {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	// One transaction for all the steps, or one per step
+	batches := [][]migrationStep{steps}
+	if c.migrationPerTx {
+		batches = make([][]migrationStep, len(steps))
+		for i := range steps {
+			batches[i] = steps[i : i+1]
+		}
+	}
+
+	// A retried work item resumes after the transactions already committed
+	committed := 0
+
+	_, err := submitSolo(ctx, c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		for committed < len(batches) {
+			events, err := c.migrateTx(ctx, db, batches[committed])
+			if err != nil {
+				return struct{}{}, err
+			}
+			committed++
+			if err := c.afterMigrations(ctx, events); err != nil {
+				return struct{}{}, err
+			}
+		}
+		return struct{}{}, nil
+	}).Get(ctx)
+	return err
+}
+
+// Execute the steps within a single transaction, the events of the committed steps are returned for the hooks.
+func (c *ComfyDB) migrateTx(ctx context.Context, db *sql.DB, steps []migrationStep) ([]MigrationEvent, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Writing the lock first keeps the other processes out until the commit
+	if err := c.renewMigrationLock(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	events := make([]MigrationEvent, 0, len(steps))
+	for _, step := range steps {
+		event, err := c.migrateStepWithHooks(ctx, step, func() error {
+			return c.migrateStep(ctx, tx, step)
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := c.renewMigrationLock(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Apply or roll back one migration and record it.
+func (c *ComfyDB) migrateStep(ctx context.Context, tx *sql.Tx, step migrationStep) error {
+	start := time.Now()
+	if err := step.run(tx); err != nil {
+		return err
+	}
+	return c.recordStep(ctx, tx, step, start)
+}
+
+// Apply or roll back the migration.
+func (s migrationStep) run(tx *sql.Tx) error {
+	if s.direction == MigrationDown {
+		return s.migration.Down(tx)
+	}
+	return s.migration.Up(tx)
+}
+
+// Record the migration as applied or remove it from the migration table.
+func (c *ComfyDB) recordStep(ctx context.Context, tx *sql.Tx, step migrationStep, start time.Time) error {
+	migration := step.migration
+
+	if step.direction == MigrationDown {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %v WHERE version = ?", c.migrationTableName), migration.Version); err != nil {
+			return fmt.Errorf("failed to delete migration (version=%v, label=%s): %w", migration.Version, migration.Label, err)
+		}
+		return nil
+	}
+
+	duration := time.Since(start)
+	records := []Migration{migration}
+	if step.covers != nil {
+		records = step.baselineRecords()
+	}
+
+	for _, record := range records {
+		if _, err := tx.ExecContext(ctx,
+			fmt.Sprintf("INSERT INTO %v (version, description, checksum, applied_at, duration) VALUES (?, ?, ?, ?, ?)", c.migrationTableName),
+			record.Version, record.Label, record.Checksum, start.UTC(), int64(duration),
+		); err != nil {
+			return fmt.Errorf("failed to insert migration (version=%v, description=%s): %w", record.Version, record.Label, err)
+		}
+	}
+	return nil
+}
+
+// Get all versions of the migrations.
+func (c *ComfyDB) Index() ([]uint, error) {
+	versions, err := SubmitRead(c, func(ctx context.Context, db *sql.DB) ([]uint, error) {
+		var versions []uint
+		rows, err := db.Query(fmt.Sprintf("SELECT version FROM %v ORDER BY version ASC", c.migrationTableName))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var version uint
+			if err := rows.Scan(&version); err != nil {
+				return nil, err
+			}
+			versions = append(versions, version)
+		}
+		return versions, nil
+	}).Get(context.Background())
+	if err == sql.ErrNoRows {
+		return []uint{}, nil
+	}
+	return versions, err
+}
+
+// Get all migrations.
+func (c *ComfyDB) Migrations() ([]Migration, error) {
+	applied, err := c.applied(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]Migration, len(applied))
+	for i, migration := range applied {
+		migrations[i] = migration.Migration
+	}
+	return migrations, nil
+}
+
+// Get current version of the migrations.
+func (c *ComfyDB) Version() (uint, error) {
+	return SubmitRead(c, func(ctx context.Context, db *sql.DB) (uint, error) {
+		var version uint
+		row := db.QueryRow(fmt.Sprintf("SELECT version FROM %v ORDER BY version DESC LIMIT 1", c.migrationTableName))
+		err := row.Scan(&version)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return 0, nil
+			}
+			return 0, err
+		}
+		return version, nil
+	}).Get(context.Background())
+}
+
+// MigrationState tells where a migration stands in the database.
+type MigrationState int
+
+const (
+	// MigrationApplied is registered and applied
+	MigrationApplied MigrationState = iota
+	// MigrationPending is registered and above the last applied version
+	MigrationPending
+	// MigrationMissing is applied but not registered
+	MigrationMissing
+	// MigrationOutOfOrder is registered below the last applied version but was never applied
+	MigrationOutOfOrder
+)
+
+func (s MigrationState) String() string {
+	switch s {
+	case MigrationApplied:
+		return "applied"
+	case MigrationPending:
+		return "pending"
+	case MigrationMissing:
+		return "missing-locally"
+	case MigrationOutOfOrder:
+		return "out-of-order"
+	default:
+		return fmt.Sprintf("MigrationState(%d)", int(s))
+	}
+}
+
+// MigrationStatus is the state of one migration version.
+type MigrationStatus struct {
+	// Migration is the registered migration, or the recorded version, label and checksum when it is missing locally
+	Migration Migration
+	State     MigrationState
+	// AppliedAt and Duration are zero when not applied or applied before they were recorded
+	AppliedAt time.Time
+	Duration  time.Duration
+}
+
+// Status merges the registered migrations with the applied ones, sorted by version.
+func (c *ComfyDB) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := c.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	registered := c.registered()
+
+	var head uint
+	byVersion := map[uint]MigrationStatus{}
+	for _, row := range applied {
+		status := MigrationStatus{
+			Migration: row.Migration,
+			State:     MigrationMissing,
+			AppliedAt: row.appliedAt,
+			Duration:  row.duration,
+		}
+		if migration, ok := registered[row.Version]; ok {
+			status.Migration = migration
+			status.State = MigrationApplied
+		} else if c.baseline != nil && c.baseline.Version == row.Version && c.baseline.Label == row.Label {
+			status.Migration = *c.baseline
+			status.State = MigrationApplied
+		}
+		byVersion[row.Version] = status
+		if row.Version > head {
+			head = row.Version
+		}
+	}
+
+	for version, migration := range registered {
+		if _, ok := byVersion[version]; ok {
+			continue
+		}
+		status := MigrationStatus{Migration: migration, State: MigrationPending}
+		if version < head {
+			status.State = MigrationOutOfOrder
+		}
+		byVersion[version] = status
+	}
+
+	statuses := make([]MigrationStatus, 0, len(byVersion))
+	for _, status := range byVersion {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Migration.Version < statuses[j].Migration.Version
+	})
+	return statuses, nil
+}
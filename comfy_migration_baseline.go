@@ -0,0 +1,129 @@
+package comfylite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+// Step applying the baseline when the database has no migration applied yet and the target is past the baseline.
+func (c *ComfyDB) baselineStep(index []uint, target uint) (migrationStep, bool) {
+	if c.baseline == nil || len(index) > 0 || target < c.baseline.Version {
+		return migrationStep{}, false
+	}
+
+	covers := []Migration{}
+	for _, migration := range c.sort() {
+		if migration.Version <= c.baseline.Version {
+			covers = append(covers, migration)
+		}
+	}
+
+	return migrationStep{migration: *c.baseline, direction: MigrationUp, covers: covers}, true
+}
+
+// Migrations recorded as applied by the baseline, the baseline itself unless a registered migration has its version.
+func (s migrationStep) baselineRecords() []Migration {
+	records := append([]Migration{}, s.covers...)
+	for _, migration := range s.covers {
+		if migration.Version == s.migration.Version {
+			return records
+		}
+	}
+	return append(records, s.migration)
+}
+
+// Check that the baseline has everything it needs.
+func (c *ComfyDB) validateBaseline() error {
+	if c.baseline == nil {
+		return nil
+	}
+	if c.baseline.Version == 0 || c.baseline.Label == "" {
+		return fmt.Errorf("%w: baseline version and label must be set", ErrInvalidMigration)
+	}
+	if c.baseline.Up == nil {
+		return fmt.Errorf("%w: baseline up must be set", ErrInvalidMigration)
+	}
+	return nil
+}
+
+// WriteBaseline writes the current schema as SQL statements, to be used as a baseline with NewSQLMigration and WithBaseline.
+// The tables come first, then the indexes, views and triggers, in their creation order. The migration tables are left out.
+func (c *ComfyDB) WriteBaseline(ctx context.Context, w io.Writer) error {
+	baseline, err := SubmitReadContext(ctx, c, func(ctx context.Context, db *sql.DB) (baselineSchema, error) {
+		return readBaseline(ctx, db, c.migrationTableName)
+	}).Get(ctx)
+	if err != nil {
+		return err
+	}
+	return baseline.write(w)
+}
+
+// WriteBaselineFrom is WriteBaseline for a database opened without comfylite3, read-only for instance,
+// migrationTableName being the name given to WithMigrationTableName. Nothing is written to the database.
+func WriteBaselineFrom(ctx context.Context, db *sql.DB, migrationTableName string, w io.Writer) error {
+	baseline, err := readBaseline(ctx, db, migrationTableName)
+	if err != nil {
+		return err
+	}
+	return baseline.write(w)
+}
+
+// Schema and version written by WriteBaseline.
+type baselineSchema struct {
+	version    uint
+	statements []string
+}
+
+// Read the last applied version and the statements of the schema, without the migration tables.
+func readBaseline(ctx context.Context, db *sql.DB, migrationTableName string) (baselineSchema, error) {
+	baseline := baselineSchema{statements: []string{}}
+
+	// A database never migrated has no migration table
+	var migrated bool
+	if err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", migrationTableName).Scan(&migrated); err != nil {
+		return baseline, err
+	}
+	if migrated {
+		err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %v", migrationTableName)).Scan(&baseline.version)
+		if err != nil {
+			return baseline, err
+		}
+	}
+
+	// The shadow tables of a virtual table, like the ones of fts, are created along with it
+	rows, err := db.QueryContext(ctx, `
+	SELECT m.sql FROM sqlite_master m
+	LEFT JOIN pragma_table_list l ON l.schema = 'main' AND l.name = m.name
+	WHERE m.sql IS NOT NULL AND m.name NOT LIKE 'sqlite_%' AND m.name NOT IN (?, ?)
+	AND COALESCE(l.type, '') != 'shadow'
+	ORDER BY CASE m.type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, m.rowid`,
+		migrationTableName, migrationTableName+"_lock")
+	if err != nil {
+		return baseline, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var statement string
+		if err := rows.Scan(&statement); err != nil {
+			return baseline, err
+		}
+		baseline.statements = append(baseline.statements, statement)
+	}
+	return baseline, rows.Err()
+}
+
+// Write the baseline file.
+func (b baselineSchema) write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "-- Baseline of the schema at version %v\n", b.version); err != nil {
+		return err
+	}
+	for _, statement := range b.statements {
+		if _, err := fmt.Fprintf(w, "\n%s;\n", statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package comfylite3
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DryRunReport is what the migrations would do, they were executed on a throwaway copy of the database.
+type DryRunReport struct {
+	Steps   []DryRunStep
+	Changes []SchemaChange
+}
+
+// DryRunStep holds the statements one migration issued.
+type DryRunStep struct {
+	Migration  Migration
+	Direction  MigrationDirection
+	Statements []string
+}
+
+// SchemaChange is one table, index, view or trigger created, dropped or altered by the migrations.
+type SchemaChange struct {
+	Type string
+	Name string
+	// Before is empty when created, After is empty when dropped
+	Before string
+	After  string
+}
+
+// DryRunUp reports what Up would do without touching the database.
+func (c *ComfyDB) DryRunUp(ctx context.Context) (*DryRunReport, error) {
+	steps, err := c.planUp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.dryRun(ctx, steps)
+}
+
+// DryRunDown reports what Down would do without touching the database.
+func (c *ComfyDB) DryRunDown(ctx context.Context, amount int) (*DryRunReport, error) {
+	steps, err := c.planDown(ctx, amount)
+	if err != nil {
+		return nil, err
+	}
+	return c.dryRun(ctx, steps)
+}
+
+// DryRunTo reports what MigrateTo would do without touching the database.
+func (c *ComfyDB) DryRunTo(ctx context.Context, version uint) (*DryRunReport, error) {
+	steps, err := c.planTo(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	return c.dryRun(ctx, steps)
+}
+
+// Execute the steps on an in-memory copy of the database, recording the statements of each migration.
+// When a migration fails, the report holds the statements up to the failure.
+func (c *ComfyDB) dryRun(ctx context.Context, steps []migrationStep) (*DryRunReport, error) {
+	recorder := &statementRecorder{}
+	copyDB := sql.OpenDB(&recordingConnector{
+		dsn:      "file:comfy_dryrun?mode=memory",
+		recorder: recorder,
+	})
+	defer copyDB.Close()
+
+	// Its single connection holds the copy
+	copyDB.SetMaxOpenConns(1)
+	copyDB.SetMaxIdleConns(1)
+	copyDB.SetConnMaxLifetime(0)
+
+	if err := c.copyInto(ctx, copyDB); err != nil {
+		return nil, err
+	}
+
+	before, err := readSchema(ctx, copyDB)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DryRunReport{Steps: []DryRunStep{}}
+
+	tx, err := copyDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, step := range steps {
+		start := time.Now()
+		recorder.start()
+		err := step.run(tx)
+		report.Steps = append(report.Steps, DryRunStep{
+			Migration:  step.migration,
+			Direction:  step.direction,
+			Statements: recorder.stop(),
+		})
+		if err == nil {
+			err = c.recordStep(ctx, tx, step, start)
+		}
+		if err != nil {
+			return report, &MigrationError{Migration: step.migration, Direction: step.direction, Err: err}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return report, err
+	}
+
+	after, err := readSchema(ctx, copyDB)
+	if err != nil {
+		return report, err
+	}
+	report.Changes = diffSchemaObjects(before, after, c.migrationTableName)
+
+	return report, nil
+}
+
+// Copy the database into the destination with the sqlite backup API, the writer is held meanwhile.
+func (c *ComfyDB) copyInto(ctx context.Context, dest *sql.DB) error {
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	_, err = submitSolo(ctx, c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		srcConn, err := db.Conn(ctx)
+		if err != nil {
+			return struct{}{}, err
+		}
+		defer srcConn.Close()
+
+		return struct{}{}, srcConn.Raw(func(src interface{}) error {
+			srcSQLite, ok := src.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("copy requires the sqlite3 driver, got %T", src)
+			}
+			return destConn.Raw(func(dst interface{}) error {
+				backup, err := dst.(*recordingConn).SQLiteConn.Backup("main", srcSQLite, "main")
+				if err != nil {
+					return err
+				}
+				if _, err := backup.Step(-1); err != nil {
+					backup.Finish()
+					return err
+				}
+				return backup.Finish()
+			})
+		})
+	}).Get(ctx)
+	return err
+}
+
+// Object of sqlite_master.
+type schemaObject struct {
+	Type string
+	Name string
+	SQL  string
+}
+
+// Read the tables, indexes, views and triggers, the internal ones of sqlite excluded.
+func readSchema(ctx context.Context, db *sql.DB) (map[string]schemaObject, error) {
+	rows, err := db.QueryContext(ctx, "SELECT type, name, COALESCE(sql, '') FROM sqlite_master WHERE name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := map[string]schemaObject{}
+	for rows.Next() {
+		var object schemaObject
+		if err := rows.Scan(&object.Type, &object.Name, &object.SQL); err != nil {
+			return nil, err
+		}
+		objects[object.Type+" "+object.Name] = object
+	}
+	return objects, rows.Err()
+}
+
+// Compare two schemas, sorted by type and name, the migration table excluded.
+func diffSchemaObjects(before, after map[string]schemaObject, migrationTableName string) []SchemaChange {
+	changes := []SchemaChange{}
+	for key, old := range before {
+		if updated, ok := after[key]; !ok {
+			changes = append(changes, SchemaChange{Type: old.Type, Name: old.Name, Before: old.SQL})
+		} else if updated.SQL != old.SQL {
+			changes = append(changes, SchemaChange{Type: old.Type, Name: old.Name, Before: old.SQL, After: updated.SQL})
+		}
+	}
+	for key, created := range after {
+		if _, ok := before[key]; !ok {
+			changes = append(changes, SchemaChange{Type: created.Type, Name: created.Name, After: created.SQL})
+		}
+	}
+
+	filtered := changes[:0]
+	for _, change := range changes {
+		if change.Name != migrationTableName {
+			filtered = append(filtered, change)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].Type != filtered[j].Type {
+			return filtered[i].Type < filtered[j].Type
+		}
+		return filtered[i].Name < filtered[j].Name
+	})
+	return filtered
+}
+
+// statementRecorder keeps the statements issued while it is started.
+type statementRecorder struct {
+	mu         sync.Mutex
+	recording  bool
+	statements []string
+}
+
+func (r *statementRecorder) start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recording = true
+	r.statements = []string{}
+}
+
+func (r *statementRecorder) stop() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recording = false
+	return r.statements
+}
+
+func (r *statementRecorder) record(query string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.recording {
+		r.statements = append(r.statements, query)
+	}
+}
+
+// recordingConnector opens sqlite3 connections recording their statements.
+type recordingConnector struct {
+	dsn      string
+	recorder *statementRecorder
+}
+
+func (rc *recordingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(rc.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), recorder: rc.recorder}, nil
+}
+
+func (rc *recordingConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+// recordingConn records the statements before handing them to sqlite3.
+type recordingConn struct {
+	*sqlite3.SQLiteConn
+	recorder *statementRecorder
+}
+
+func (rc *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	rc.recorder.record(query)
+	return rc.SQLiteConn.Prepare(query)
+}
+
+func (rc *recordingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	rc.recorder.record(query)
+	return rc.SQLiteConn.PrepareContext(ctx, query)
+}
+
+func (rc *recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rc.recorder.record(query)
+	return rc.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+func (rc *recordingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rc.recorder.record(query)
+	return rc.SQLiteConn.QueryContext(ctx, query, args)
+}
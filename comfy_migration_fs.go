@@ -0,0 +1,144 @@
+package comfylite3
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Name of a migration file: 0001_genesis.up.sql or 0001_genesis.down.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Directory of a file system holding SQL migration files.
+type migrationSource struct {
+	fsys fs.FS
+	dir  string
+}
+
+// WithMigrationsFS records the SQL migration files found in the directory of the file system, an embed.FS for example.
+// Files are named after their version and label, 0001_genesis.up.sql and 0001_genesis.down.sql,
+// they can hold several statements and can be mixed with the migrations of WithMigration.
+func WithMigrationsFS(fsys fs.FS, dir string) ComfyOption {
+	return func(c *ComfyDB) {
+		c.migrationSources = append(c.migrationSources, migrationSource{fsys: fsys, dir: dir})
+	}
+}
+
+// Read the migration files of the sources and register them next to the other migrations.
+func (c *ComfyDB) loadMigrationSources() error {
+	versions := map[uint]string{}
+	for _, migration := range c.migrations {
+		versions[migration.Version] = migration.Label
+	}
+
+	for _, source := range c.migrationSources {
+		migrations, err := readMigrationFiles(source.fsys, source.dir)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if label, ok := versions[migration.Version]; ok {
+				return fmt.Errorf("%w: version %v is registered by %s and %s", ErrInvalidMigration, migration.Version, label, migration.Label)
+			}
+			versions[migration.Version] = migration.Label
+			c.migrations = append(c.migrations, migration)
+		}
+	}
+
+	return nil
+}
+
+// Parse the up and down files of a directory into migrations.
+func readMigrationFiles(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type files struct {
+		label          string
+		up, down       string
+		hasUp, hasDown bool
+	}
+	byVersion := map[uint]*files{}
+	order := []uint{}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidMigration, entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		f, ok := byVersion[uint(version)]
+		if !ok {
+			f = &files{label: match[2]}
+			byVersion[uint(version)] = f
+			order = append(order, uint(version))
+		} else if f.label != match[2] {
+			return nil, fmt.Errorf("%w: version %v is labeled %s and %s", ErrInvalidMigration, version, f.label, match[2])
+		}
+
+		if match[3] == "up" {
+			f.up, f.hasUp = string(content), true
+		} else {
+			f.down, f.hasDown = string(content), true
+		}
+	}
+
+	migrations := []Migration{}
+	for _, version := range order {
+		f := byVersion[version]
+		if !f.hasUp || !f.hasDown {
+			return nil, fmt.Errorf("%w: %s needs both an up and a down file", ErrInvalidMigration, f.label)
+		}
+		migrations = append(migrations, NewSQLMigration(version, f.label, f.up, f.down))
+	}
+
+	return migrations, nil
+}
+
+// NewSQLMigration creates a migration executing the statements of the up and down SQL, its checksum is their hash.
+func NewSQLMigration(version uint, label string, up, down string) Migration {
+	migration := NewMigration(version, label, execSQL(up), execSQL(down))
+	migration.Checksum = fileChecksum(up, down)
+	return migration
+}
+
+// Hash of the content of the up and down files.
+func fileChecksum(up, down string) string {
+	hash := sha256.New()
+	hash.Write([]byte(up))
+	hash.Write([]byte{0})
+	hash.Write([]byte(down))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Execute the statements of a migration file within the migration transaction.
+func execSQL(statements string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		if strings.TrimSpace(statements) == "" {
+			return nil
+		}
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
@@ -0,0 +1,71 @@
+package comfylite3
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MigrationEvent describes one migration step given to the hooks.
+type MigrationEvent struct {
+	Migration Migration
+	Direction MigrationDirection
+	// Duration is zero before the migration
+	Duration time.Duration
+	// Err is only set for OnMigrationError
+	Err error
+}
+
+// MigrationHooks are called around each migration of Up, Down and MigrateTo, every hook is optional.
+// They run within the work item of the writer executing the migrations: a hook must not use the ComfyDB
+// the migrations belong to, Exec, Status, Version or any other work would wait for the writer forever.
+type MigrationHooks struct {
+	// BeforeMigration is called before the migration, an error aborts the migrations
+	BeforeMigration func(ctx context.Context, event MigrationEvent) error
+	// AfterMigration is called once the migration is committed: after each one with WithTransactionPerMigration,
+	// after all of them otherwise. An error aborts the migrations left, the committed ones stay
+	AfterMigration func(ctx context.Context, event MigrationEvent) error
+	// OnMigrationError is called when the migration failed or was aborted
+	OnMigrationError func(ctx context.Context, event MigrationEvent)
+}
+
+// Execute one migration step after the BeforeMigration hook, the event is for the AfterMigration hook once committed.
+func (c *ComfyDB) migrateStepWithHooks(ctx context.Context, step migrationStep, fn func() error) (MigrationEvent, error) {
+	event := MigrationEvent{Migration: step.migration, Direction: step.direction}
+
+	if c.migrationHooks.BeforeMigration != nil {
+		if err := c.migrationHooks.BeforeMigration(ctx, event); err != nil {
+			return event, c.migrationFailed(ctx, event, fmt.Errorf("%w: %w", ErrMigrationAborted, err))
+		}
+	}
+
+	start := time.Now()
+	err := fn()
+	event.Duration = time.Since(start)
+	if err != nil {
+		return event, c.migrationFailed(ctx, event, err)
+	}
+	return event, nil
+}
+
+// Call the AfterMigration hook for the committed migrations, in order.
+func (c *ComfyDB) afterMigrations(ctx context.Context, events []MigrationEvent) error {
+	if c.migrationHooks.AfterMigration == nil {
+		return nil
+	}
+	for _, event := range events {
+		if err := c.migrationHooks.AfterMigration(ctx, event); err != nil {
+			return c.migrationFailed(ctx, event, fmt.Errorf("%w: %w", ErrMigrationAborted, err))
+		}
+	}
+	return nil
+}
+
+// Call the OnMigrationError hook and wrap the error.
+func (c *ComfyDB) migrationFailed(ctx context.Context, event MigrationEvent, err error) error {
+	event.Err = err
+	if c.migrationHooks.OnMigrationError != nil {
+		c.migrationHooks.OnMigrationError(ctx, event)
+	}
+	return &MigrationError{Migration: event.Migration, Direction: event.Direction, Err: err}
+}
@@ -0,0 +1,171 @@
+package comfylite3
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Delay between two attempts to take the migration lock while waiting for it
+const migrationLockPoll = 50 * time.Millisecond
+
+// Name of the table holding the migration lock.
+func (c *ComfyDB) migrationLockTable() string {
+	return c.migrationTableName + "_lock"
+}
+
+// Identify this instance as the owner of the migration lock, across processes.
+func newLockOwner() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return fmt.Sprintf("%d-%s", os.Getpid(), hex.EncodeToString(id))
+}
+
+// Execute the migration while holding the migration lock, when enabled.
+func (c *ComfyDB) withMigrationLock(ctx context.Context, fn func() error) error {
+	if c.migrationLease <= 0 {
+		return fn()
+	}
+
+	if err := c.lockMigrations(ctx); err != nil {
+		return err
+	}
+
+	// The lease is extended while the migrations wait in the queue or run, the migration transactions renew it too
+	stop := make(chan struct{})
+	renewed := make(chan struct{})
+	go c.renewMigrationLockEvery(ctx, stop, renewed)
+
+	err := fn()
+
+	close(stop)
+	<-renewed
+
+	// The lease expires anyway if the lock can't be released
+	if unlockErr := c.unlockMigrations(ctx); err == nil {
+		err = unlockErr
+	}
+	return err
+}
+
+// Take the migration lock, waiting for it as long as configured.
+func (c *ComfyDB) lockMigrations(ctx context.Context) error {
+	deadline := time.Now().Add(c.migrationLockWait)
+	for {
+		err := c.tryLockMigrations(ctx)
+		if err == nil || !errors.Is(err, ErrMigrationInProgress) || time.Now().After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationLockPoll):
+		}
+	}
+}
+
+// Take the migration lock if it is free, expired or already ours.
+// BEGIN IMMEDIATE makes sure no other process reads the lock at the same time.
+func (c *ComfyDB) tryLockMigrations(ctx context.Context) error {
+	_, err := submitSolo(ctx, c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		// The writer has a single connection, the statements below are part of the transaction
+		if _, err := db.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+			if c.retryable(err) {
+				return struct{}{}, fmt.Errorf("%w: %v", ErrMigrationInProgress, err)
+			}
+			return struct{}{}, err
+		}
+		committed := false
+		defer func() {
+			if !committed {
+				db.Exec("ROLLBACK")
+			}
+		}()
+
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %v (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			owner TEXT NOT NULL,
+			expires_at INTEGER NOT NULL
+		)`, c.migrationLockTable())); err != nil {
+			return struct{}{}, err
+		}
+
+		var owner string
+		var expiresAt int64
+		err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT owner, expires_at FROM %v WHERE id = 1", c.migrationLockTable())).Scan(&owner, &expiresAt)
+		if err != nil && err != sql.ErrNoRows {
+			return struct{}{}, err
+		}
+		now := time.Now()
+		if err == nil && owner != c.lockOwner && now.UnixNano() < expiresAt {
+			return struct{}{}, fmt.Errorf("%w: held by %s until %v", ErrMigrationInProgress, owner, time.Unix(0, expiresAt).Format(time.RFC3339))
+		}
+
+		if _, err := db.ExecContext(ctx,
+			fmt.Sprintf("INSERT OR REPLACE INTO %v (id, owner, expires_at) VALUES (1, ?, ?)", c.migrationLockTable()),
+			c.lockOwner, now.Add(c.migrationLease).UnixNano(),
+		); err != nil {
+			return struct{}{}, err
+		}
+
+		if _, err := db.ExecContext(ctx, "COMMIT"); err != nil {
+			return struct{}{}, err
+		}
+		committed = true
+		return struct{}{}, nil
+	}).Get(ctx)
+	return err
+}
+
+// Extend the lease of the migration lock a third of the lease at a time until stopped.
+// A failed renewal is left to the next one, the migration transactions fail if the lock was lost.
+func (c *ComfyDB) renewMigrationLockEvery(ctx context.Context, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(max(c.migrationLease/3, migrationLockPoll))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			SubmitContext(ContextWithPriority(ctx, PriorityHigh), c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+				return struct{}{}, c.renewMigrationLock(ctx, db)
+			}).Get(ctx)
+		}
+	}
+}
+
+// Extend the lease of the migration lock, within the migration transaction or from the writer.
+// The lock was lost if it isn't ours anymore, the lease expired and another process took it.
+func (c *ComfyDB) renewMigrationLock(ctx context.Context, db execQuerier) error {
+	if c.migrationLease <= 0 {
+		return nil
+	}
+	result, err := db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %v SET expires_at = ? WHERE id = 1 AND owner = ?", c.migrationLockTable()),
+		time.Now().Add(c.migrationLease).UnixNano(), c.lockOwner,
+	)
+	if err != nil {
+		return err
+	}
+	if renewed, err := result.RowsAffected(); err != nil || renewed == 0 {
+		return fmt.Errorf("%w: the lease of the migration lock expired", ErrMigrationInProgress)
+	}
+	return nil
+}
+
+// Release the migration lock if it is still ours.
+func (c *ComfyDB) unlockMigrations(ctx context.Context) error {
+	_, err := submitSolo(ctx, c, func(ctx context.Context, db *sql.DB) (sql.Result, error) {
+		return db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %v WHERE id = 1 AND owner = ?", c.migrationLockTable()), c.lockOwner)
+	}).Get(ctx)
+	return err
+}
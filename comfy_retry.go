@@ -0,0 +1,65 @@
+package comfylite3
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davidroman0O/retrypool"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Default amount of executions of a work item failing with a retryable error
+const defaultRetryAttempts = 3
+
+// UnlimitedRetries given to WithRetryAttempts executes a work item again until it succeeds or fails with an error that isn't retryable.
+const UnlimitedRetries = -1
+
+// Default delay before the first retry
+const defaultRetryDelay = 10 * time.Millisecond
+
+// Longest delay between two retries
+const maxRetryDelay = time.Second
+
+// RetryClassifier decides if the error returned by a work item is worth executing it again.
+type RetryClassifier func(err error) bool
+
+// IsRetryable reports if the error is a transient sqlite error: busy, locked or busy snapshot.
+func IsRetryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy ||
+		sqliteErr.Code == sqlite3.ErrLocked ||
+		sqliteErr.ExtendedCode == sqlite3.ErrBusySnapshot
+}
+
+// Called by the retrypool each time a work item returned a retryable error.
+func (c *ComfyDB) onTaskFailure(item *workItem, err error) retrypool.TaskAction {
+	item.attempts++
+	// Zero executes the work item once, like one
+	if c.retryAttempts >= 0 && item.attempts >= max(c.retryAttempts, 1) {
+		// Out of attempts, the waiter gets the last error
+		item.done(nil, err)
+		return retrypool.TaskActionRemove
+	}
+	// Closed meanwhile, the retrypool would drop it
+	if item.sched != nil && !item.sched.retry(item) {
+		item.done(nil, fmt.Errorf("%w: %v", ErrClosed, err))
+		return retrypool.TaskActionRemove
+	}
+	return retrypool.TaskActionRetry
+}
+
+// Exponential backoff starting from the retry delay.
+func (c *ComfyDB) retryBackoff(retries int, err error, config *retrypool.Config[*workItem]) time.Duration {
+	delay := c.retryDelay
+	for i := 1; i < retries && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
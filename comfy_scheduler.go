@@ -0,0 +1,301 @@
+package comfylite3
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/davidroman0O/retrypool"
+)
+
+// Priority of a work item, higher lanes are served first.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+
+	priorityCount = int(PriorityHigh) + 1
+)
+
+// Default amount of higher priority items served before a waiting lower priority item gets its turn
+const defaultPriorityBurst = 8
+
+type priorityKey struct{}
+
+// ContextWithPriority returns a context carrying the priority of the work submitted with it.
+// Use it with NewContext, SubmitContext or the sql.DB-like functions taking a context.
+func ContextWithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority carried by the context, PriorityNormal by default.
+func PriorityFromContext(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		if priority < PriorityLow {
+			return PriorityLow
+		}
+		if priority > PriorityHigh {
+			return PriorityHigh
+		}
+		return priority
+	}
+	return PriorityNormal
+}
+
+type lane struct {
+	items []*workItem
+	// skipped counts the items served from higher lanes while this one was waiting
+	skipped int
+}
+
+// scheduler holds the work items in priority lanes and only hands the retrypool as many items as it has workers.
+type scheduler struct {
+	mu       sync.Mutex
+	pool     *retrypool.Pool[*workItem]
+	capacity int
+	inflight int
+	burst    int
+	closed   bool
+	// aborted is set by close, the work items waiting for a retry fail too
+	aborted bool
+	lanes   [priorityCount]lane
+	// retrying holds the running work items waiting in the retrypool to be executed again
+	retrying map[*workItem]struct{}
+	// idle is closed once the scheduler is stopped with nothing queued nor running
+	idle       chan struct{}
+	idleClosed bool
+	// paused counts the pauses not resumed yet, drained is closed once paused with nothing running
+	paused        int
+	drained       chan struct{}
+	drainedClosed bool
+}
+
+func newScheduler(pool *retrypool.Pool[*workItem], capacity, burst int) *scheduler {
+	if burst <= 0 {
+		burst = defaultPriorityBurst
+	}
+	return &scheduler{
+		pool:     pool,
+		capacity: capacity,
+		burst:    burst,
+	}
+}
+
+// Queue the work item in its lane.
+func (s *scheduler) push(item *workItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		item.done(nil, ErrClosed)
+		return
+	}
+	item.sched = s
+	l := &s.lanes[item.priority]
+	l.items = append(l.items, item)
+	s.pump()
+}
+
+// Release the slot of a finished work item.
+func (s *scheduler) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight--
+	s.pump()
+	s.checkIdle()
+	s.checkDrained()
+}
+
+// Hand the next work items to the retrypool while workers are available.
+// s.mu is already held by caller
+func (s *scheduler) pump() {
+	for s.paused == 0 && s.inflight < s.capacity {
+		item := s.next()
+		if item == nil {
+			return
+		}
+		s.inflight++
+		if err := s.pool.Submit(item); err != nil {
+			// It never made it to a worker, release the slot ourselves
+			s.inflight--
+			item.sched = nil
+			item.done(nil, fmt.Errorf("%w: %v", ErrClosed, err))
+		}
+	}
+}
+
+// Refuse new work items and fail the queued ones, and the ones waiting for a retry.
+func (s *scheduler) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.aborted = true
+	for p := range s.lanes {
+		for _, item := range s.lanes[p].items {
+			item.sched = nil
+			item.done(nil, ErrClosed)
+		}
+		s.lanes[p].items = nil
+	}
+	// The retrypool drops them when closed, their slots are released once they are done
+	abandoned := make([]*workItem, 0, len(s.retrying))
+	for item := range s.retrying {
+		item.abandoned = true
+		abandoned = append(abandoned, item)
+	}
+	s.retrying = nil
+	s.checkIdle()
+	s.mu.Unlock()
+
+	for _, item := range abandoned {
+		item.done(nil, ErrClosed)
+	}
+}
+
+// Keep track of the work item while it waits for its retry, false once closed: it must fail instead.
+func (s *scheduler) retry(item *workItem) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aborted {
+		return false
+	}
+	if s.retrying == nil {
+		s.retrying = map[*workItem]struct{}{}
+	}
+	s.retrying[item] = struct{}{}
+	return true
+}
+
+// Take the work item back to execute it, false when close already failed it.
+func (s *scheduler) retried(item *workItem) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.retrying, item)
+	return !item.abandoned
+}
+
+// Refuse new work items but keep executing the queued ones.
+// The returned channel is closed when nothing is queued nor running anymore.
+func (s *scheduler) stop() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.idle == nil {
+		s.idle = make(chan struct{})
+	}
+	s.checkIdle()
+	return s.idle
+}
+
+// Keep the work items queued, the running ones go on.
+// The returned channel is closed when nothing is running anymore, every pause must be resumed.
+func (s *scheduler) pause() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused++
+	if s.drained == nil {
+		s.drained = make(chan struct{})
+		s.drainedClosed = false
+	}
+	s.checkDrained()
+	return s.drained
+}
+
+// Hand the queued work items to the retrypool again once every pause is resumed.
+func (s *scheduler) resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused--
+	if s.paused > 0 {
+		return
+	}
+	s.drained = nil
+	s.pump()
+}
+
+// s.mu is already held by caller
+func (s *scheduler) checkDrained() {
+	if s.paused == 0 || s.inflight > 0 || s.drained == nil || s.drainedClosed {
+		return
+	}
+	s.drainedClosed = true
+	close(s.drained)
+}
+
+// s.mu is already held by caller
+func (s *scheduler) checkIdle() {
+	if s.idle == nil || s.idleClosed || s.inflight > 0 {
+		return
+	}
+	for p := range s.lanes {
+		if len(s.lanes[p].items) > 0 {
+			return
+		}
+	}
+	s.idleClosed = true
+	close(s.idle)
+}
+
+// Pick the next work item, the highest lane first unless a lower lane waited for too long.
+// s.mu is already held by caller
+func (s *scheduler) next() *workItem {
+	selected := s.selectLane()
+	if selected == -1 {
+		return nil
+	}
+	return s.pop(selected)
+}
+
+// Take the next work items as long as they are accepted, at most max of them.
+// They are counted as running, each of them releases its slot when done.
+func (s *scheduler) nextBatch(max int, accept func(item *workItem) bool) []*workItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := []*workItem{}
+	for s.paused == 0 && len(items) < max {
+		selected := s.selectLane()
+		if selected == -1 || !accept(s.lanes[selected].items[0]) {
+			break
+		}
+		s.inflight++
+		items = append(items, s.pop(selected))
+	}
+	return items
+}
+
+// Select the lane to serve next, -1 when nothing is queued.
+// s.mu is already held by caller
+func (s *scheduler) selectLane() int {
+	selected := -1
+	for p := priorityCount - 1; p >= 0; p-- {
+		if len(s.lanes[p].items) == 0 {
+			continue
+		}
+		if selected == -1 {
+			selected = p
+			continue
+		}
+		if s.lanes[p].skipped >= s.burst {
+			selected = p
+		}
+	}
+	return selected
+}
+
+// Remove the first work item of the selected lane.
+// s.mu is already held by caller
+func (s *scheduler) pop(selected int) *workItem {
+	// Every lower lane still waiting got skipped once more
+	for p := selected - 1; p >= 0; p-- {
+		if len(s.lanes[p].items) > 0 {
+			s.lanes[p].skipped++
+		}
+	}
+
+	l := &s.lanes[selected]
+	l.skipped = 0
+	item := l.items[0]
+	l.items[0] = nil
+	l.items = l.items[1:]
+	return item
+}
@@ -0,0 +1,264 @@
+package comfylite3
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// Schema describes the tables, views and triggers of the database.
+type Schema struct {
+	Tables   []Table
+	Views    []View
+	Triggers []Trigger
+}
+
+// Table describes one table with its columns, indexes and foreign keys.
+type Table struct {
+	Name string
+	// SQL is the CREATE statement of the table
+	SQL          string
+	WithoutRowID bool
+	Strict       bool
+	Columns      []TableColumn
+	Indexes      []Index
+	ForeignKeys  []ForeignKey
+}
+
+// TableColumn is a column as seen by PRAGMA table_xinfo, generated and hidden columns included.
+type TableColumn struct {
+	Column
+	// PkOrder is the position of the column in the primary key starting at 1, 0 when not part of it
+	PkOrder int
+	// Hidden is set for the hidden columns of virtual tables
+	Hidden bool
+	// Generated is VIRTUAL or STORED for a generated column, empty otherwise
+	Generated string
+}
+
+// Index describes one index of a table, the ones sqlite creates for the UNIQUE and PRIMARY KEY constraints included.
+type Index struct {
+	Name   string
+	Unique bool
+	// Origin is "c" for CREATE INDEX, "u" for a UNIQUE constraint and "pk" for a PRIMARY KEY
+	Origin  string
+	Partial bool
+	// Columns are in the order of the index, an expression is an empty name
+	Columns []string
+	// SQL is the CREATE statement of the index, empty for the ones sqlite creates
+	SQL string
+}
+
+// ForeignKey describes one foreign key of a table.
+type ForeignKey struct {
+	// Table is the referenced table
+	Table string
+	From  []string
+	// To is empty when the foreign key references the primary key
+	To       []string
+	OnUpdate string
+	OnDelete string
+	Match    string
+}
+
+// View describes one view.
+type View struct {
+	Name string
+	SQL  string
+}
+
+// Trigger describes one trigger and the table or view it is attached to.
+type Trigger struct {
+	Name  string
+	Table string
+	SQL   string
+}
+
+// SchemaOptions changes what Schema reads.
+type SchemaOptions struct {
+	// IncludeInternal keeps the sqlite tables like sqlite_sequence and the migration tables
+	IncludeInternal bool
+}
+
+// Schema reads the tables, views, indexes, foreign keys and triggers of the database.
+func (c *ComfyDB) Schema(ctx context.Context, opts SchemaOptions) (*Schema, error) {
+	return SubmitReadContext(ctx, c, func(ctx context.Context, db *sql.DB) (*Schema, error) {
+		return c.introspect(ctx, db, opts)
+	}).Get(ctx)
+}
+
+// Whether the object is one of sqlite or of the migrations.
+func (c *ComfyDB) internalObject(name string) bool {
+	return strings.HasPrefix(name, "sqlite_") || name == c.migrationTableName || name == c.migrationLockTable()
+}
+
+// Read the schema through the connection.
+func (c *ComfyDB) introspect(ctx context.Context, db *sql.DB, opts SchemaOptions) (*Schema, error) {
+	schema := &Schema{Tables: []Table{}, Views: []View{}, Triggers: []Trigger{}}
+
+	rows, err := db.QueryContext(ctx, "SELECT type, name, tbl_name, COALESCE(sql, '') FROM sqlite_master WHERE type IN ('table', 'view', 'trigger') ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, name, table, createSQL string
+		if err := rows.Scan(&kind, &name, &table, &createSQL); err != nil {
+			return nil, err
+		}
+		if !opts.IncludeInternal && c.internalObject(table) {
+			continue
+		}
+		switch kind {
+		case "table":
+			schema.Tables = append(schema.Tables, Table{Name: name, SQL: createSQL})
+		case "view":
+			schema.Views = append(schema.Views, View{Name: name, SQL: createSQL})
+		case "trigger":
+			schema.Triggers = append(schema.Triggers, Trigger{Name: name, Table: table, SQL: createSQL})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range schema.Tables {
+		if err := readTable(ctx, db, &schema.Tables[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return schema, nil
+}
+
+// Read the flags, columns, indexes and foreign keys of a table.
+func readTable(ctx context.Context, db *sql.DB, table *Table) error {
+	// The virtual tables aren't listed when their module is missing
+	err := db.QueryRowContext(ctx, "SELECT wr, strict FROM pragma_table_list(?) WHERE schema = 'main'", table.Name).Scan(&table.WithoutRowID, &table.Strict)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if table.Columns, err = readColumns(ctx, db, table.Name); err != nil {
+		return err
+	}
+	if table.Indexes, err = readIndexes(ctx, db, table.Name); err != nil {
+		return err
+	}
+	if table.ForeignKeys, err = readForeignKeys(ctx, db, table.Name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Columns of a table, hidden and generated ones included.
+func readColumns(ctx context.Context, db *sql.DB, table string) ([]TableColumn, error) {
+	rows, err := db.QueryContext(ctx, "SELECT cid, name, type, \"notnull\", dflt_value, pk, hidden FROM pragma_table_xinfo(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []TableColumn{}
+	for rows.Next() {
+		var col TableColumn
+		var hidden int
+		if err := rows.Scan(&col.CID, &col.Name, &col.Type, &col.NotNull, &col.DfltValue, &col.PkOrder, &hidden); err != nil {
+			return nil, err
+		}
+		col.Pk = col.PkOrder > 0
+		// 1 is hidden, 2 generated virtual and 3 generated stored
+		switch hidden {
+		case 1:
+			col.Hidden = true
+		case 2:
+			col.Generated = "VIRTUAL"
+		case 3:
+			col.Generated = "STORED"
+		}
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+// Indexes of a table with their columns.
+func readIndexes(ctx context.Context, db *sql.DB, table string) ([]Index, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT il.name, il."unique", il.origin, il.partial, COALESCE(m.sql, '')
+	FROM pragma_index_list(?) il LEFT JOIN sqlite_master m ON m.type = 'index' AND m.name = il.name
+	ORDER BY il.name`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := []Index{}
+	for rows.Next() {
+		var index Index
+		if err := rows.Scan(&index.Name, &index.Unique, &index.Origin, &index.Partial, &index.SQL); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range indexes {
+		columns, err := db.QueryContext(ctx, "SELECT name FROM pragma_index_info(?) ORDER BY seqno", indexes[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		indexes[i].Columns = []string{}
+		for columns.Next() {
+			var name sql.NullString
+			if err := columns.Scan(&name); err != nil {
+				columns.Close()
+				return nil, err
+			}
+			indexes[i].Columns = append(indexes[i].Columns, name.String)
+		}
+		err = columns.Err()
+		columns.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return indexes, nil
+}
+
+// Foreign keys of a table, the columns of a composite key grouped together.
+func readForeignKeys(ctx context.Context, db *sql.DB, table string) ([]ForeignKey, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, "table", "from", "to", on_update, on_delete, "match" FROM pragma_foreign_key_list(?) ORDER BY id, seq`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []ForeignKey{}
+	lastID := -1
+	for rows.Next() {
+		var id int
+		var key ForeignKey
+		var from string
+		var to sql.NullString
+		if err := rows.Scan(&id, &key.Table, &from, &to, &key.OnUpdate, &key.OnDelete, &key.Match); err != nil {
+			return nil, err
+		}
+		if id != lastID {
+			key.From, key.To = []string{}, []string{}
+			keys = append(keys, key)
+			lastID = id
+		}
+		current := &keys[len(keys)-1]
+		current.From = append(current.From, from)
+		if to.Valid {
+			current.To = append(current.To, to.String)
+		}
+	}
+	return keys, rows.Err()
+}
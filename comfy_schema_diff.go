@@ -0,0 +1,514 @@
+package comfylite3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SchemaDiff lists what differs from the schema of one database to the schema of another.
+type SchemaDiff struct {
+	Differences []SchemaDifference
+	from, to    *Schema
+	tables      map[string]*tableDiff
+}
+
+// SchemaDifference is one table, column, index or foreign key added, removed or changed.
+type SchemaDifference struct {
+	// Type is table, column, index or foreign key
+	Type string
+	// Table owning the column, index or foreign key, the table itself otherwise
+	Table string
+	Name  string
+	// Before is empty when added, After is empty when removed
+	Before string
+	After  string
+}
+
+// How a table present on both sides changes.
+type tableDiff struct {
+	// rebuild is set when ALTER TABLE can't make the change
+	rebuild bool
+	// Definitions of the columns added with ALTER TABLE, as written in the CREATE statement
+	addColumns []string
+	// Indexes created with CREATE INDEX to drop or create, the table isn't rebuilt
+	dropIndexes   []Index
+	createIndexes []Index
+}
+
+// DiffSchema compares the schemas of the databases, what changes to get from a to b.
+// The internal tables are left out, views and triggers aren't compared.
+func DiffSchema(ctx context.Context, a, b *ComfyDB) (*SchemaDiff, error) {
+	from, err := a.Schema(ctx, SchemaOptions{})
+	if err != nil {
+		return nil, err
+	}
+	to, err := b.Schema(ctx, SchemaOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return diffSchemas(from, to), nil
+}
+
+// Compare two schemas, sorted by table.
+func diffSchemas(from, to *Schema) *SchemaDiff {
+	diff := &SchemaDiff{Differences: []SchemaDifference{}, from: from, to: to, tables: map[string]*tableDiff{}}
+
+	before := map[string]Table{}
+	for _, table := range from.Tables {
+		before[table.Name] = table
+	}
+	after := map[string]Table{}
+	for _, table := range to.Tables {
+		after[table.Name] = table
+	}
+
+	for _, table := range from.Tables {
+		if _, ok := after[table.Name]; !ok {
+			diff.add("table", table.Name, table.Name, table.SQL, "")
+		}
+	}
+	for _, table := range to.Tables {
+		old, ok := before[table.Name]
+		if !ok {
+			diff.add("table", table.Name, table.Name, "", table.SQL)
+			continue
+		}
+		diff.diffTable(old, table)
+	}
+
+	return diff
+}
+
+func (d *SchemaDiff) add(kind, table, name, before, after string) {
+	d.Differences = append(d.Differences, SchemaDifference{Type: kind, Table: table, Name: name, Before: before, After: after})
+}
+
+// Compare the flags, columns, indexes and foreign keys of a table.
+func (d *SchemaDiff) diffTable(old, updated Table) {
+	table := &tableDiff{}
+
+	if old.WithoutRowID != updated.WithoutRowID || old.Strict != updated.Strict {
+		d.add("table", updated.Name, updated.Name, old.SQL, updated.SQL)
+		table.rebuild = true
+	}
+
+	oldColumns := map[string]TableColumn{}
+	for _, col := range old.Columns {
+		oldColumns[col.Name] = col
+	}
+	newColumns := map[string]TableColumn{}
+	for _, col := range updated.Columns {
+		newColumns[col.Name] = col
+	}
+	definitions := columnDefinitions(updated.SQL)
+	for _, col := range old.Columns {
+		if _, ok := newColumns[col.Name]; !ok {
+			d.add("column", updated.Name, col.Name, describeColumn(col), "")
+			table.rebuild = true
+		}
+	}
+	for _, col := range updated.Columns {
+		oldCol, ok := oldColumns[col.Name]
+		if !ok {
+			d.add("column", updated.Name, col.Name, "", describeColumn(col))
+			if definition, ok := definitions[col.Name]; ok && addableColumn(col) {
+				table.addColumns = append(table.addColumns, definition)
+			} else {
+				table.rebuild = true
+			}
+		} else if describeColumn(oldCol) != describeColumn(col) {
+			d.add("column", updated.Name, col.Name, describeColumn(oldCol), describeColumn(col))
+			table.rebuild = true
+		}
+	}
+
+	oldIndexes := map[string]Index{}
+	for _, index := range old.Indexes {
+		oldIndexes[index.Name] = index
+	}
+	newIndexes := map[string]Index{}
+	for _, index := range updated.Indexes {
+		newIndexes[index.Name] = index
+	}
+	for _, index := range old.Indexes {
+		if _, ok := newIndexes[index.Name]; !ok {
+			d.add("index", updated.Name, index.Name, describeIndex(index), "")
+			if index.SQL == "" {
+				table.rebuild = true
+			} else {
+				table.dropIndexes = append(table.dropIndexes, index)
+			}
+		}
+	}
+	for _, index := range updated.Indexes {
+		oldIndex, ok := oldIndexes[index.Name]
+		if ok && describeIndex(oldIndex) == describeIndex(index) {
+			continue
+		}
+		if ok {
+			d.add("index", updated.Name, index.Name, describeIndex(oldIndex), describeIndex(index))
+		} else {
+			d.add("index", updated.Name, index.Name, "", describeIndex(index))
+		}
+		// The indexes of the constraints are part of the table
+		if index.SQL == "" || (ok && oldIndex.SQL == "") {
+			table.rebuild = true
+			continue
+		}
+		if ok {
+			table.dropIndexes = append(table.dropIndexes, oldIndex)
+		}
+		table.createIndexes = append(table.createIndexes, index)
+	}
+
+	oldKeys := map[string]bool{}
+	for _, key := range old.ForeignKeys {
+		oldKeys[describeForeignKey(key)] = true
+	}
+	newKeys := map[string]bool{}
+	for _, key := range updated.ForeignKeys {
+		newKeys[describeForeignKey(key)] = true
+	}
+	for _, key := range old.ForeignKeys {
+		if description := describeForeignKey(key); !newKeys[description] {
+			d.add("foreign key", updated.Name, key.Table, description, "")
+			table.rebuild = true
+		}
+	}
+	for _, key := range updated.ForeignKeys {
+		if description := describeForeignKey(key); !oldKeys[description] {
+			d.add("foreign key", updated.Name, key.Table, "", description)
+			table.rebuild = true
+		}
+	}
+
+	d.tables[updated.Name] = table
+}
+
+// Whether ALTER TABLE ADD COLUMN can add the column.
+func addableColumn(col TableColumn) bool {
+	// The expression of a generated column isn't known
+	if col.Pk || col.Hidden || col.Generated != "" {
+		return false
+	}
+	// A NOT NULL column needs a default for the existing rows
+	if col.NotNull && col.DfltValue == nil {
+		return false
+	}
+	// ADD COLUMN only takes a constant default, not an expression or the current time
+	if col.DfltValue != nil {
+		switch value := strings.ToUpper(*col.DfltValue); {
+		case strings.HasPrefix(value, "("), value == "CURRENT_TIME", value == "CURRENT_DATE", value == "CURRENT_TIMESTAMP":
+			return false
+		}
+	}
+	return true
+}
+
+// Definition of a column as compared.
+func describeColumn(col TableColumn) string {
+	parts := []string{col.Type}
+	if col.NotNull {
+		parts = append(parts, "NOT NULL")
+	}
+	if col.DfltValue != nil {
+		parts = append(parts, "DEFAULT "+*col.DfltValue)
+	}
+	if col.Pk {
+		parts = append(parts, fmt.Sprintf("PRIMARY KEY %d", col.PkOrder))
+	}
+	if col.Generated != "" {
+		parts = append(parts, "GENERATED "+col.Generated)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Definition of an index as compared.
+func describeIndex(index Index) string {
+	if index.SQL != "" {
+		return index.SQL
+	}
+	description := fmt.Sprintf("(%s)", strings.Join(index.Columns, ", "))
+	if index.Unique {
+		description = "UNIQUE " + description
+	}
+	return description
+}
+
+// Definition of a foreign key as compared.
+func describeForeignKey(key ForeignKey) string {
+	description := fmt.Sprintf("(%s) REFERENCES %s", strings.Join(key.From, ", "), key.Table)
+	if len(key.To) > 0 {
+		description += fmt.Sprintf(" (%s)", strings.Join(key.To, ", "))
+	}
+	return fmt.Sprintf("%s ON UPDATE %s ON DELETE %s MATCH %s", description, key.OnUpdate, key.OnDelete, key.Match)
+}
+
+// DDL returns the statements turning the first schema into the second, to be executed in order.
+// ALTER TABLE is used when it can make the change, the columns added as written in the CREATE statement of the second schema.
+// The table is rebuilt otherwise: created under a new name
+// from the second schema, filled with the common columns, then renamed once the old one is dropped.
+// The statements disable the foreign keys while rebuilding, so they must be executed outside of a transaction
+// or the rebuilds may fail on the references between tables.
+func (d *SchemaDiff) DDL() []string {
+	statements := []string{}
+
+	before := map[string]Table{}
+	for _, table := range d.from.Tables {
+		before[table.Name] = table
+	}
+	after := map[string]Table{}
+	for _, table := range d.to.Tables {
+		after[table.Name] = table
+	}
+
+	rebuilds := []Table{}
+	for _, table := range d.to.Tables {
+		if diff, ok := d.tables[table.Name]; ok && diff.rebuild {
+			rebuilds = append(rebuilds, table)
+		}
+	}
+	dropping := dropsTables(d.from.Tables, after)
+
+	// The views would break the renames and the drops, they are created again at the end
+	if len(rebuilds) > 0 || dropping {
+		statements = append(statements, "PRAGMA foreign_keys = OFF")
+		for _, view := range d.from.Views {
+			statements = append(statements, fmt.Sprintf("DROP VIEW %s", quoteIdentifier(view.Name)))
+		}
+	}
+
+	for _, table := range d.from.Tables {
+		if _, ok := after[table.Name]; !ok {
+			statements = append(statements, fmt.Sprintf("DROP TABLE %s", quoteIdentifier(table.Name)))
+		}
+	}
+
+	for _, table := range d.to.Tables {
+		if _, ok := before[table.Name]; !ok {
+			statements = append(statements, table.SQL)
+			statements = append(statements, d.createdWith(table)...)
+			continue
+		}
+
+		diff := d.tables[table.Name]
+		if diff.rebuild {
+			statements = append(statements, rebuildTable(before[table.Name], table)...)
+			statements = append(statements, d.createdWith(table)...)
+			continue
+		}
+
+		for _, index := range diff.dropIndexes {
+			statements = append(statements, fmt.Sprintf("DROP INDEX %s", quoteIdentifier(index.Name)))
+		}
+		for _, definition := range diff.addColumns {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quoteIdentifier(table.Name), definition))
+		}
+		for _, index := range diff.createIndexes {
+			statements = append(statements, index.SQL)
+		}
+	}
+
+	if len(rebuilds) > 0 || dropping {
+		for _, view := range d.to.Views {
+			statements = append(statements, view.SQL)
+		}
+		statements = append(statements, "PRAGMA foreign_key_check", "PRAGMA foreign_keys = ON")
+	}
+
+	return statements
+}
+
+// Whether a table of the first schema is dropped.
+func dropsTables(tables []Table, after map[string]Table) bool {
+	for _, table := range tables {
+		if _, ok := after[table.Name]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Indexes and triggers created along with a table of the second schema.
+func (d *SchemaDiff) createdWith(table Table) []string {
+	statements := []string{}
+	for _, index := range table.Indexes {
+		if index.SQL != "" {
+			statements = append(statements, index.SQL)
+		}
+	}
+	for _, trigger := range d.to.Triggers {
+		if trigger.Table == table.Name {
+			statements = append(statements, trigger.SQL)
+		}
+	}
+	return statements
+}
+
+// Statements of the table rebuild, the indexes and triggers of the table are dropped along with it.
+func rebuildTable(old, updated Table) []string {
+	temporary := "comfy_new_" + updated.Name
+
+	oldColumns := map[string]bool{}
+	for _, col := range old.Columns {
+		if col.Generated == "" && !col.Hidden {
+			oldColumns[col.Name] = true
+		}
+	}
+	columns := []string{}
+	for _, col := range updated.Columns {
+		if col.Generated == "" && !col.Hidden && oldColumns[col.Name] {
+			columns = append(columns, quoteIdentifier(col.Name))
+		}
+	}
+
+	statements := []string{renameCreateTable(updated.SQL, temporary)}
+	if len(columns) > 0 {
+		list := strings.Join(columns, ", ")
+		statements = append(statements, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", quoteIdentifier(temporary), list, list, quoteIdentifier(old.Name)))
+	}
+	return append(statements,
+		fmt.Sprintf("DROP TABLE %s", quoteIdentifier(old.Name)),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdentifier(temporary), quoteIdentifier(updated.Name)),
+	)
+}
+
+// Change the name of the table created by a CREATE TABLE statement.
+func renameCreateTable(createSQL, name string) string {
+	open := openingParen(createSQL)
+	if open < 0 {
+		return createSQL
+	}
+	return fmt.Sprintf("CREATE TABLE %s %s", quoteIdentifier(name), createSQL[open:])
+}
+
+// Position of the parenthesis opening the definitions of a CREATE TABLE statement, -1 without one.
+// The name of the table is skipped, quoted it may contain a parenthesis.
+func openingParen(createSQL string) int {
+	for i := 0; i < len(createSQL); i++ {
+		if end := skipToken(createSQL, i); end > i {
+			i = end - 1
+		} else if createSQL[i] == '(' {
+			return i
+		}
+	}
+	return -1
+}
+
+// Position after the quoted identifier, string or comment starting at i, i when there is none.
+func skipToken(s string, i int) int {
+	var end string
+	switch {
+	case s[i] == '"' || s[i] == '\'' || s[i] == '`':
+		end = s[i : i+1]
+	case s[i] == '[':
+		end = "]"
+	case strings.HasPrefix(s[i:], "--"):
+		end = "\n"
+	case strings.HasPrefix(s[i:], "/*"):
+		end = "*/"
+	default:
+		return i
+	}
+	for j := i + 1; j < len(s); j++ {
+		if !strings.HasPrefix(s[j:], end) {
+			continue
+		}
+		// A doubled quote is part of the name or the string
+		if len(end) == 1 && end != "]" && end != "\n" && j+1 < len(s) && s[j+1] == end[0] {
+			j++
+			continue
+		}
+		return j + len(end)
+	}
+	return len(s)
+}
+
+// Definitions of the columns of a CREATE TABLE statement, by column name, with their constraints as written.
+func columnDefinitions(createSQL string) map[string]string {
+	definitions := map[string]string{}
+	open := openingParen(createSQL)
+	if open < 0 {
+		return definitions
+	}
+
+	// Split on the commas outside of parentheses, until the parenthesis closing the definitions
+	elements := []string{}
+	depth, start := 0, open+1
+	for i := start; i < len(createSQL) && depth >= 0; i++ {
+		if end := skipToken(createSQL, i); end > i {
+			i = end - 1
+			continue
+		}
+		switch createSQL[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				elements = append(elements, createSQL[start:i])
+			}
+		case ',':
+			if depth == 0 {
+				elements = append(elements, createSQL[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	for _, element := range elements {
+		element = strings.TrimSpace(stripComments(element))
+		if element == "" {
+			continue
+		}
+		end := skipToken(element, 0)
+		quoted := end > 0
+		if !quoted {
+			end = strings.IndexAny(element, " \t\r\n")
+			if end < 0 {
+				end = len(element)
+			}
+		}
+		name := element[:end]
+		if !quoted {
+			// The table constraints come after the columns
+			switch strings.ToUpper(name) {
+			case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+				continue
+			}
+		} else if len(name) >= 2 {
+			quote := name[:1]
+			name = name[1 : len(name)-1]
+			if quote != "[" {
+				name = strings.ReplaceAll(name, quote+quote, quote)
+			}
+		}
+		definitions[name] = element
+	}
+	return definitions
+}
+
+// Replace the comments with spaces, the statements are executed one after the other.
+func stripComments(s string) string {
+	var stripped strings.Builder
+	for i := 0; i < len(s); i++ {
+		end := skipToken(s, i)
+		switch {
+		case end == i:
+			stripped.WriteByte(s[i])
+			continue
+		case strings.HasPrefix(s[i:], "--") || strings.HasPrefix(s[i:], "/*"):
+			stripped.WriteByte(' ')
+		default:
+			stripped.WriteString(s[i:end])
+		}
+		i = end - 1
+	}
+	return stripped.String()
+}
+
+// Quote an identifier for sqlite.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,318 @@
+package comfylite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Timestamp in the name of a snapshot file, in UTC
+const snapshotTimeFormat = "20060102T150405.000000000"
+
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".db"
+)
+
+// SnapshotRetention decides which snapshots are kept, the latest one always is.
+type SnapshotRetention struct {
+	// Count is the amount of snapshots kept, all of them when zero
+	Count int
+	// MaxAge removes the older snapshots, none when zero
+	MaxAge time.Duration
+}
+
+// Snapshot is a copy of the database taken by the snapshots.
+type Snapshot struct {
+	Path      string
+	CreatedAt time.Time
+	Size      int64
+}
+
+// WithSnapshots takes a snapshot of the database every interval into the directory, a copy written with VACUUM INTO
+// and checked with PRAGMA integrity_check. The older snapshots are removed according to the retention.
+// See WithSnapshotErrorHandler for the errors of the snapshots taken in the background.
+func WithSnapshots(dir string, interval time.Duration, keep SnapshotRetention) ComfyOption {
+	return func(c *ComfyDB) {
+		c.snapshotDir = dir
+		c.snapshotInterval = interval
+		c.snapshotRetention = keep
+	}
+}
+
+// WithSnapshotErrorHandler sets the function called when a snapshot taken in the background fails.
+func WithSnapshotErrorHandler(fn func(err error)) ComfyOption {
+	return func(c *ComfyDB) {
+		c.onSnapshotError = fn
+	}
+}
+
+// Take a snapshot every interval until stopped.
+func (c *ComfyDB) snapshotEvery(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(c.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// The snapshots are taken when the database is idle
+			ctx := ContextWithPriority(context.Background(), PriorityLow)
+			if _, err := c.Snapshot(ctx); err != nil && !errors.Is(err, ErrClosed) && c.onSnapshotError != nil {
+				c.onSnapshotError(err)
+			}
+		}
+	}
+}
+
+// Stop taking snapshots in the background, the one being taken is finished first.
+// Close and Shutdown may race, only the first one stops them.
+func (c *ComfyDB) stopSnapshotting() {
+	c.stopSnapshotsOnce.Do(func() {
+		if c.stopSnapshots == nil {
+			return
+		}
+		close(c.stopSnapshots)
+		<-c.snapshotsDone
+	})
+}
+
+// Snapshot takes a snapshot of the database now, checks it and removes the older ones according to the retention.
+func (c *ComfyDB) Snapshot(ctx context.Context) (Snapshot, error) {
+	if c.snapshotDir == "" {
+		return Snapshot{}, ErrSnapshotsDisabled
+	}
+	if err := os.MkdirAll(c.snapshotDir, 0o755); err != nil {
+		return Snapshot{}, err
+	}
+
+	createdAt := time.Now().UTC()
+	path := filepath.Join(c.snapshotDir, snapshotPrefix+createdAt.Format(snapshotTimeFormat)+snapshotSuffix)
+
+	// The snapshot is only listed once checked
+	temporary := path + ".tmp"
+	os.Remove(temporary)
+
+	// VACUUM INTO only reads the database, a reader is enough
+	_, err := SubmitReadContext(ctx, c, func(ctx context.Context, db *sql.DB) (sql.Result, error) {
+		return db.ExecContext(ctx, "VACUUM INTO ?", temporary)
+	}).Get(ctx)
+	if err == nil {
+		err = checkIntegrity(ctx, temporary)
+	}
+	if err == nil {
+		err = os.Rename(temporary, path)
+	}
+	if err != nil {
+		os.Remove(temporary)
+		return Snapshot{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot := Snapshot{Path: path, CreatedAt: createdAt, Size: info.Size()}
+
+	return snapshot, c.pruneSnapshots(snapshot)
+}
+
+// Remove the snapshots the retention doesn't keep, never the latest one.
+// A restore running meanwhile may be reading one of them, the pruning waits for it.
+func (c *ComfyDB) pruneSnapshots(latest Snapshot) error {
+	c.restoreMu.Lock()
+	defer c.restoreMu.Unlock()
+
+	snapshots, err := c.ListSnapshots()
+	if err != nil {
+		return err
+	}
+
+	// Newest first
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snapshot := snapshots[i]
+		if snapshot.Path == latest.Path {
+			continue
+		}
+		kept := len(snapshots) - i
+		tooMany := c.snapshotRetention.Count > 0 && kept > c.snapshotRetention.Count
+		tooOld := c.snapshotRetention.MaxAge > 0 && latest.CreatedAt.Sub(snapshot.CreatedAt) > c.snapshotRetention.MaxAge
+		if tooMany || tooOld {
+			if err := os.Remove(snapshot.Path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ListSnapshots returns the snapshots of the directory of WithSnapshots, the oldest first.
+func (c *ComfyDB) ListSnapshots() ([]Snapshot, error) {
+	if c.snapshotDir == "" {
+		return nil, ErrSnapshotsDisabled
+	}
+
+	entries, err := os.ReadDir(c.snapshotDir)
+	if os.IsNotExist(err) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		createdAt, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Path: filepath.Join(c.snapshotDir, name), CreatedAt: createdAt, Size: info.Size()})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// RestoreSnapshot replaces the content of the database with the snapshot, any copy of the database works.
+// The queue is drained first: the running work finishes and the queued work waits. The snapshot is opened read-only
+// and checked, then its pages are copied into the database with the sqlite backup API, in a single transaction,
+// and the queued work goes on with the restored database. The snapshots aren't pruned during a restore.
+func (c *ComfyDB) RestoreSnapshot(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	// One restore at a time, the queue stays paused until the last one is over
+	c.restoreMu.Lock()
+	defer c.restoreMu.Unlock()
+
+	drained := []<-chan struct{}{c.writer.pause()}
+	defer c.writer.resume()
+	if c.reader != nil {
+		drained = append(drained, c.reader.pause())
+		defer c.reader.resume()
+	}
+	for _, done := range drained {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// Read-only, a missing file fails instead of being created empty, and checked on the connection copied from
+	sourceDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return err
+	}
+	defer sourceDB.Close()
+	source, err := sourceDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	if err := integrityCheck(ctx, source, path); err != nil {
+		return err
+	}
+
+	// Nothing runs anymore, the writer connection is ours
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return source.Raw(func(src interface{}) error {
+		srcSQLite, ok := src.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("restore requires the sqlite3 driver, got %T", src)
+		}
+		return conn.Raw(func(dest interface{}) error {
+			destSQLite, ok := dest.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("restore requires the sqlite3 driver, got %T", dest)
+			}
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			// A busy database leaves the copy unfinished without an error
+			done, err := backup.Step(-1)
+			if err == nil && !done {
+				err = errors.New("the database is busy, the snapshot wasn't restored")
+			}
+			if err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// Run PRAGMA integrity_check on the database file.
+func checkIntegrity(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrSnapshotCorrupt, path, err)
+	}
+	defer conn.Close()
+
+	return integrityCheck(ctx, conn, path)
+}
+
+// Run PRAGMA integrity_check through the connection opened on the file at path.
+func integrityCheck(ctx context.Context, conn *sql.Conn, path string) error {
+	rows, err := conn.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrSnapshotCorrupt, path, err)
+	}
+	defer rows.Close()
+
+	problems := []string{}
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return err
+		}
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrSnapshotCorrupt, path, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrSnapshotCorrupt, path, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"sync"
 	"time"
 )
 
@@ -11,224 +12,163 @@ import (
 
 // implement Ping() error of sql.DB with Comfy
 func (c *ComfyDB) Ping() error {
-	pingID := c.New(func(db *sql.DB) (interface{}, error) {
-		return nil, db.Ping()
-	})
-	result := <-c.WaitForChn(pingID)
-	switch data := result.(type) {
-	case error:
-		return data
-	default:
-		return nil
-	}
+	_, err := Submit(c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		return struct{}{}, db.Ping()
+	}).Get(context.Background())
+	return err
 }
 
 func (c *ComfyDB) Begin() (*sql.Tx, error) {
-	txID := c.New(func(db *sql.DB) (interface{}, error) {
+	return submitSolo(context.Background(), c, func(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
 		return db.Begin()
-	})
-	result := <-c.WaitForChn(txID)
-	switch data := result.(type) {
-	case *sql.Tx:
-		return data, nil
-	default:
-		return nil, data.(error)
-	}
+	}).Get(context.Background())
 }
 
 func (c *ComfyDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
-	txID := c.New(func(db *sql.DB) (interface{}, error) {
+	return submitSolo(ctx, c, func(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
 		return db.BeginTx(ctx, opts)
-	})
-	result := <-c.WaitForChn(txID)
-	switch data := result.(type) {
-	case *sql.Tx:
-		return data, nil
-	default:
-		return nil, data.(error)
-	}
+	}).Get(ctx)
 }
 
 func (c *ComfyDB) Conn(ctx context.Context) (*sql.Conn, error) {
-	connID := c.New(func(db *sql.DB) (interface{}, error) {
+	return submitSolo(ctx, c, func(ctx context.Context, db *sql.DB) (*sql.Conn, error) {
 		return db.Conn(ctx)
-	})
-	result := <-c.WaitForChn(connID)
-	switch data := result.(type) {
-	case *sql.Conn:
-		return data, nil
-	default:
-		return nil, data.(error)
-	}
+	}).Get(ctx)
 }
 
 func (c *ComfyDB) Driver() driver.Driver {
-	driverID := c.New(func(db *sql.DB) (interface{}, error) {
+	value, _ := Submit(c, func(ctx context.Context, db *sql.DB) (driver.Driver, error) {
 		return db.Driver(), nil
-	})
-	result := <-c.WaitForChn(driverID)
-	switch data := result.(type) {
-	case driver.Driver:
-		return data
-	default:
-		return nil
-	}
+	}).Get(context.Background())
+	return value
 }
 
 func (c *ComfyDB) Exec(query string, args ...interface{}) (sql.Result, error) {
-	execID := c.New(func(db *sql.DB) (interface{}, error) {
+	return Submit(c, func(ctx context.Context, db *sql.DB) (sql.Result, error) {
 		return db.Exec(query, args...)
-	})
-	result := <-c.WaitForChn(execID)
-	switch data := result.(type) {
-	case sql.Result:
-		return data, nil
-	default:
-		return nil, data.(error)
-	}
+	}).Get(context.Background())
 }
 
 func (c *ComfyDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	execID := c.New(func(db *sql.DB) (interface{}, error) {
+	return SubmitContext(ctx, c, func(ctx context.Context, db *sql.DB) (sql.Result, error) {
 		return db.ExecContext(ctx, query, args...)
-	})
-	result := <-c.WaitForChn(execID)
-	switch data := result.(type) {
-	case sql.Result:
-		return data, nil
-	default:
-		return nil, data.(error)
-	}
+	}).Get(ctx)
 }
 
 func (c *ComfyDB) PingContext(ctx context.Context) error {
-	pingID := c.New(func(db *sql.DB) (interface{}, error) {
-		return nil, db.PingContext(ctx)
-	})
-	result := <-c.WaitForChn(pingID)
-	switch data := result.(type) {
-	case error:
-		return data
-	default:
-		return nil
-	}
+	_, err := SubmitContext(ctx, c, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		return struct{}{}, db.PingContext(ctx)
+	}).Get(ctx)
+	return err
 }
 
 func (c *ComfyDB) Prepare(query string) (*sql.Stmt, error) {
-	stmtID := c.New(func(db *sql.DB) (interface{}, error) {
+	return Submit(c, func(ctx context.Context, db *sql.DB) (*sql.Stmt, error) {
 		return db.Prepare(query)
-	})
-	result := <-c.WaitForChn(stmtID)
-	switch data := result.(type) {
-	case *sql.Stmt:
-		return data, nil
-	default:
-		return nil, data.(error)
-	}
+	}).Get(context.Background())
 }
 
 func (c *ComfyDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
-	stmtID := c.New(func(db *sql.DB) (interface{}, error) {
+	return SubmitContext(ctx, c, func(ctx context.Context, db *sql.DB) (*sql.Stmt, error) {
 		return db.PrepareContext(ctx, query)
-	})
-	result := <-c.WaitForChn(stmtID)
-	switch data := result.(type) {
-	case *sql.Stmt:
-		return data, nil
-	default:
-		return nil, data.(error)
-	}
+	}).Get(ctx)
 }
 
 func (c *ComfyDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	rowsID := c.New(func(db *sql.DB) (interface{}, error) {
+	return SubmitRead(c, func(ctx context.Context, db *sql.DB) (*sql.Rows, error) {
 		return db.Query(query, args...)
-	})
-	result := <-c.WaitForChn(rowsID)
-	switch data := result.(type) {
-	case *sql.Rows:
-		return data, nil
-	default:
-		return nil, data.(error)
-	}
+	}).Get(context.Background())
 }
 
 func (c *ComfyDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	rowsID := c.New(func(db *sql.DB) (interface{}, error) {
+	return SubmitReadContext(ctx, c, func(ctx context.Context, db *sql.DB) (*sql.Rows, error) {
 		return db.QueryContext(ctx, query, args...)
-	})
-	result := <-c.WaitForChn(rowsID)
-	switch data := result.(type) {
-	case *sql.Rows:
-		return data, nil
-	default:
-		return nil, data.(error)
-	}
+	}).Get(ctx)
 }
 
+// QueryRow schedules the query, the row fails with ErrClosed once the database is closed.
 func (c *ComfyDB) QueryRow(query string, args ...interface{}) *sql.Row {
-	rowID := c.New(func(db *sql.DB) (interface{}, error) {
+	row, err := SubmitRead(c, func(ctx context.Context, db *sql.DB) (*sql.Row, error) {
 		return db.QueryRow(query, args...), nil
-	})
-	result := <-c.WaitForChn(rowID)
-	switch data := result.(type) {
-	case *sql.Row:
-		return data
-	default:
-		return nil
+	}).Get(context.Background())
+	if err != nil {
+		return failedRow(err)
 	}
+	return row
 }
 
+// QueryRowContext schedules the query with the priority of the context, cancelling it gives up on the queued work.
+// The row then fails with the error of the context, or with ErrClosed once the database is closed.
 func (c *ComfyDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	rowID := c.New(func(db *sql.DB) (interface{}, error) {
+	row, err := SubmitReadContext(ctx, c, func(ctx context.Context, db *sql.DB) (*sql.Row, error) {
 		return db.QueryRowContext(ctx, query, args...), nil
-	})
-	result := <-c.WaitForChn(rowID)
-	switch data := result.(type) {
-	case *sql.Row:
-		return data
-	default:
-		return nil
+	}).Get(ctx)
+	if err != nil {
+		return failedRow(err)
 	}
+	return row
+}
+
+// Database never connected to, it only builds the rows of failedRow
+var failedRowsDB = sync.OnceValue(func() *sql.DB {
+	db, _ := sql.Open("sqlite3", ":memory:")
+	return db
+})
+
+// Channel closed once and for all, the Done of failedContext
+var closedDone = func() chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}()
+
+// Context already done with an error of our own.
+type failedContext struct {
+	context.Context
+	err error
+}
+
+func (f failedContext) Done() <-chan struct{} { return closedDone }
+
+func (f failedContext) Err() error { return f.err }
+
+// A row failing with err when scanned. database/sql only builds a failed row from a done context,
+// it gives up with the error of the context before using a connection.
+func failedRow(err error) *sql.Row {
+	return failedRowsDB().QueryRowContext(failedContext{Context: context.Background(), err: err}, "SELECT 1")
 }
 
 func (c *ComfyDB) SetConnMaxIdleTime(d time.Duration) {
-	c.New(func(db *sql.DB) (interface{}, error) {
+	c.Fire(func(db *sql.DB) (interface{}, error) {
 		db.SetConnMaxIdleTime(d)
 		return nil, nil
 	})
 }
 
 func (c *ComfyDB) SetConnMaxLifetime(d time.Duration) {
-	c.New(func(db *sql.DB) (interface{}, error) {
+	c.Fire(func(db *sql.DB) (interface{}, error) {
 		db.SetConnMaxLifetime(d)
 		return nil, nil
 	})
 }
 
 func (c *ComfyDB) SetMaxIdleConns(n int) {
-	c.New(func(db *sql.DB) (interface{}, error) {
+	c.Fire(func(db *sql.DB) (interface{}, error) {
 		db.SetMaxIdleConns(n)
 		return nil, nil
 	})
 }
 
 func (c *ComfyDB) SetMaxOpenConns(n int) {
-	c.New(func(db *sql.DB) (interface{}, error) {
+	c.Fire(func(db *sql.DB) (interface{}, error) {
 		db.SetMaxOpenConns(n)
 		return nil, nil
 	})
 }
 
 func (c *ComfyDB) Stats() sql.DBStats {
-	statsID := c.New(func(db *sql.DB) (interface{}, error) {
+	stats, _ := Submit(c, func(ctx context.Context, db *sql.DB) (sql.DBStats, error) {
 		return db.Stats(), nil
-	})
-	result := <-c.WaitForChn(statsID)
-	switch data := result.(type) {
-	case sql.DBStats:
-		return data
-	default:
-		return sql.DBStats{}
-	}
+	}).Get(context.Background())
+	return stats
 }
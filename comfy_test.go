@@ -1,7 +1,9 @@
 package comfylite3
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math/rand"
@@ -9,6 +11,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/mattn/go-sqlite3"
 )
 
 func TestMemory(t *testing.T) {
@@ -415,3 +419,1006 @@ func TestLockedGist(t *testing.T) {
 	// }
 
 }
+
+func TestDriverTransaction(t *testing.T) {
+
+	comfyMe, err := New(
+		WithMemory(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer comfyMe.Close()
+
+	db := OpenDB(comfyMe)
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func() int {
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&total); err != nil {
+			t.Fatal(err)
+		}
+		return total
+	}
+
+	// Rollback must discard every statement of the transaction
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "Jane Smith"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "John Doe"); err != nil {
+		t.Fatal(err)
+	}
+	var inside int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users").Scan(&inside); err != nil {
+		t.Fatal(err)
+	}
+	if inside != 2 {
+		t.Fatalf("expected 2 users inside the transaction, got %d", inside)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if total := count(); total != 0 {
+		t.Fatalf("expected 0 users after rollback, got %d", total)
+	}
+
+	// Other work waits in the queue until the transaction is committed
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "Jane Smith"); err != nil {
+		t.Fatal(err)
+	}
+
+	outsideID := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		var total int
+		err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&total)
+		return total, err
+	})
+	outside := comfyMe.WaitForChn(outsideID)
+
+	select {
+	case <-outside:
+		t.Fatal("work executed while the transaction was pinned")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	switch data := (<-outside).(type) {
+	case int:
+		if data != 1 {
+			t.Fatalf("expected 1 user after commit, got %d", data)
+		}
+	default:
+		t.Fatalf("unexpected result %v", data)
+	}
+
+	if err := tx.Rollback(); err != sql.ErrTxDone {
+		t.Fatalf("expected ErrTxDone, got %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+
+	comfyMe, err := New(
+		WithMemory(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer comfyMe.Close()
+
+	// Keep the worker busy so the next work stays in the queue
+	release := make(chan struct{})
+	blockID := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+
+	executed := false
+	ctx, cancel := context.WithCancel(context.Background())
+	skippedID := comfyMe.NewContext(ctx, func(ctx context.Context, db *sql.DB) (interface{}, error) {
+		executed = true
+		return nil, nil
+	})
+
+	// The waiter chooses its own deadline
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer waitCancel()
+	if _, err := comfyMe.WaitForContext(waitCtx, skippedID); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	cancel()
+	close(release)
+	<-comfyMe.WaitForChn(blockID)
+
+	result, err := comfyMe.WaitForContext(context.Background(), skippedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != context.Canceled {
+		t.Fatalf("expected context canceled, got %v", result)
+	}
+	if executed {
+		t.Fatal("cancelled work was executed")
+	}
+
+	// The context is handed to the function
+	type key struct{}
+	valueID := comfyMe.NewContext(context.WithValue(context.Background(), key{}, "comfy"), func(ctx context.Context, db *sql.DB) (interface{}, error) {
+		return ctx.Value(key{}), nil
+	})
+	if result, err = comfyMe.WaitForContext(context.Background(), valueID); err != nil {
+		t.Fatal(err)
+	}
+	if result != "comfy" {
+		t.Fatalf("expected context value, got %v", result)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+
+	comfyMe, err := New(
+		WithMemory(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer comfyMe.Close()
+
+	if _, err := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (sql.Result, error) {
+		return db.ExecContext(ctx, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	}).Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	future := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+		var total int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&total)
+		return total, err
+	})
+	total, err := future.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 {
+		t.Fatalf("expected 0 users, got %d", total)
+	}
+
+	// Get can be called again once the result is there
+	if total, err = future.Get(context.Background()); err != nil || total != 0 {
+		t.Fatalf("expected the same result, got %d %v", total, err)
+	}
+
+	// An error returned as a value is data, not a failure
+	errValue := fmt.Errorf("stored error")
+	value, err := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (error, error) {
+		return errValue, nil
+	}).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != errValue {
+		t.Fatalf("expected the error value, got %v", value)
+	}
+
+	// Failures are returned as errors
+	if _, err := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (sql.Result, error) {
+		return db.ExecContext(ctx, "INSERT INTO nope (name) VALUES (?)", "Jane Smith")
+	}).Get(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// Work submitted with a done context is skipped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := SubmitContext(ctx, comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+		t.Fatal("cancelled work was executed")
+		return 0, nil
+	}).Get(context.Background()); err != context.Canceled {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestReadPool(t *testing.T) {
+
+	if err := deleteTestDbFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	comfyMe, err := New(
+		WithPath("test.db"),
+		WithReadPool(4),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer deleteTestDbFile()
+	defer comfyMe.Close()
+
+	if _, err := comfyMe.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := comfyMe.Exec("INSERT INTO users (name) VALUES (?)", "Jane Smith"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold a write transaction open on the writer
+	started := make(chan struct{})
+	release := make(chan struct{})
+	writeID := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "John Doe"); err != nil {
+			return nil, err
+		}
+		close(started)
+		<-release
+		return nil, tx.Commit()
+	})
+	<-started
+
+	// Readers proceed concurrently and only see committed data
+	futures := []*Future[int]{}
+	for i := 0; i < 8; i++ {
+		futures = append(futures, SubmitRead(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+			var total int
+			err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&total)
+			return total, err
+		}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, future := range futures {
+		total, err := future.Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 1 {
+			t.Fatalf("expected 1 committed user, got %d", total)
+		}
+	}
+
+	var name string
+	if err := comfyMe.QueryRow("SELECT name FROM users WHERE id = 1").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Jane Smith" {
+		t.Fatalf("expected Jane Smith, got %s", name)
+	}
+
+	// The row fails with the context instead of waiting for the queue
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := comfyMe.QueryRowContext(cancelled, "SELECT name FROM users WHERE id = 1").Scan(&name); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// Writes are rejected by the readers
+	if _, err := SubmitRead(comfyMe, func(ctx context.Context, db *sql.DB) (sql.Result, error) {
+		return db.ExecContext(ctx, "INSERT INTO users (name) VALUES (?)", "Doe Smith")
+	}).Get(ctx); err == nil {
+		t.Fatal("expected the reader to be read-only")
+	}
+
+	close(release)
+	if result := <-comfyMe.WaitForChn(writeID); result != nil {
+		t.Fatal(result)
+	}
+
+	rows, err := comfyMe.Query("SELECT name FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	total := 0
+	for rows.Next() {
+		total++
+	}
+	if total != 2 {
+		t.Fatalf("expected 2 users after commit, got %d", total)
+	}
+
+	if _, err := New(WithMemory(), WithReadPool(2)); err == nil {
+		t.Fatal("expected an error for a memory database with a read pool")
+	}
+}
+
+func TestPriority(t *testing.T) {
+
+	comfyMe, err := New(
+		WithMemory(),
+		WithPriorityBurst(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer comfyMe.Close()
+
+	// Keep the worker busy while the lanes fill up
+	release := make(chan struct{})
+	blockID := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+
+	var order []string
+	record := func(name string) SqlFn {
+		return func(db *sql.DB) (interface{}, error) {
+			order = append(order, name)
+			return nil, nil
+		}
+	}
+
+	ids := []uint64{}
+	for i := 0; i < 3; i++ {
+		ids = append(ids, comfyMe.NewWithPriority(PriorityLow, record("L")))
+	}
+	for i := 0; i < 6; i++ {
+		ids = append(ids, comfyMe.NewWithPriority(PriorityHigh, record("H")))
+	}
+
+	close(release)
+	<-comfyMe.WaitForChn(blockID)
+	for _, id := range ids {
+		<-comfyMe.WaitForChn(id)
+	}
+
+	// High lane first, the low lane still progresses every two items
+	if got := strings.Join(order, ""); got != "HHLHHLHHL" {
+		t.Fatalf("unexpected order %s", got)
+	}
+
+	// The priority goes through the context of the sql.DB-like functions
+	ctx := ContextWithPriority(context.Background(), PriorityHigh)
+	if PriorityFromContext(ctx) != PriorityHigh {
+		t.Fatal("expected the high priority from the context")
+	}
+	if PriorityFromContext(context.Background()) != PriorityNormal {
+		t.Fatal("expected the normal priority by default")
+	}
+	if err := comfyMe.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPanic(t *testing.T) {
+
+	var recovered interface{}
+	comfyMe, err := New(
+		WithMemory(),
+		WithPanicHandler(func(v interface{}, stackTrace string) {
+			recovered = v
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer comfyMe.Close()
+
+	id := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		panic("oops")
+	})
+	if err, _ := (<-comfyMe.WaitForChn(id)).(error); !errors.Is(err, ErrWorkPanic) {
+		t.Fatalf("expected a panic error, got %v", err)
+	}
+	if recovered != "oops" {
+		t.Fatalf("expected the panic handler to be called, got %v", recovered)
+	}
+
+	// The scheduler keeps going
+	if err := comfyMe.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+
+	comfyMe, err := New(
+		WithMemory(),
+		WithRetryAttempts(3),
+		WithRetryDelay(time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer comfyMe.Close()
+
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	// Busy errors are executed again until they succeed
+	calls := 0
+	value, err := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, busy
+		}
+		return calls, nil
+	}).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 3 {
+		t.Fatalf("expected 3 executions, got %d", value)
+	}
+
+	// Out of attempts, the last error is delivered
+	calls = 0
+	if _, err := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+		calls++
+		return 0, sqlite3.Error{Code: sqlite3.ErrLocked}
+	}).Get(context.Background()); !IsRetryable(err) {
+		t.Fatalf("expected a locked error, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 executions, got %d", calls)
+	}
+
+	// Other errors are delivered right away
+	if _, err := comfyMe.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT UNIQUE)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := comfyMe.Exec("INSERT INTO users (name) VALUES (?)", "Jane Smith"); err != nil {
+		t.Fatal(err)
+	}
+	calls = 0
+	if _, err := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (sql.Result, error) {
+		calls++
+		return db.ExecContext(ctx, "INSERT INTO users (name) VALUES (?)", "Jane Smith")
+	}).Get(context.Background()); err == nil || IsRetryable(err) {
+		t.Fatalf("expected a constraint error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 execution, got %d", calls)
+	}
+
+	// Old style work is retried too
+	calls = 0
+	id := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		calls++
+		if calls < 2 {
+			return nil, fmt.Errorf("wrapped: %w", busy)
+		}
+		return "ok", nil
+	})
+	if result := <-comfyMe.WaitForChn(id); result != "ok" {
+		t.Fatalf("expected ok, got %v", result)
+	}
+
+	// Zero never retries, UnlimitedRetries never gives up
+	for attempts, expected := range map[int]int{0: 1, UnlimitedRetries: 6} {
+		comfyAttempts, err := New(
+			WithMemory(),
+			WithRetryAttempts(attempts),
+			WithRetryDelay(time.Millisecond),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls := 0
+		Submit(comfyAttempts, func(ctx context.Context, db *sql.DB) (int, error) {
+			calls++
+			if calls < 6 {
+				return 0, busy
+			}
+			return calls, nil
+		}).Get(context.Background())
+		comfyAttempts.Close()
+		if calls != expected {
+			t.Fatalf("expected %d executions with %d attempts, got %d", expected, attempts, calls)
+		}
+	}
+}
+
+func TestRetryClassifier(t *testing.T) {
+
+	errFlaky := fmt.Errorf("flaky")
+
+	comfyMe, err := New(
+		WithMemory(),
+		WithRetryDelay(time.Millisecond),
+		WithRetryClassifier(func(err error) bool {
+			return err == errFlaky
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer comfyMe.Close()
+
+	calls := 0
+	value, err := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+		calls++
+		if calls == 1 {
+			return 0, errFlaky
+		}
+		return calls, nil
+	}).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 2 {
+		t.Fatalf("expected 2 executions, got %d", value)
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	comfyMe, err := New(
+		WithMemory(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := comfyMe.WaitFor(424242); !errors.Is(err, ErrWorkNotFound) {
+		t.Fatalf("expected ErrWorkNotFound, got %v", err)
+	}
+	if result := <-comfyMe.WaitForChn(424242); result != ErrWorkNotFound {
+		t.Fatalf("expected ErrWorkNotFound, got %v", result)
+	}
+
+	// sqlite errors go through untouched
+	_, err = comfyMe.Exec("INSERT INTO nope (name) VALUES (?)", "Jane Smith")
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		t.Fatalf("expected a sqlite3.Error, got %v", err)
+	}
+
+	// Queued work fails when closing
+	started := make(chan struct{})
+	release := make(chan struct{})
+	blockID := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	queued := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+		return 1, nil
+	})
+
+	if err := comfyMe.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-comfyMe.WaitForChn(blockID)
+
+	if _, err := queued.Get(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed for the queued work, got %v", err)
+	}
+
+	// No more panic after Close
+	id := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		return nil, nil
+	})
+	if result := <-comfyMe.WaitForChn(id); result != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", result)
+	}
+	if _, err := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+		return 1, nil
+	}).Get(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	var one int
+	if err := comfyMe.QueryRow("SELECT 1").Scan(&one); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from the row, got %v", err)
+	}
+	if err := comfyMe.QueryRowContext(context.Background(), "SELECT 1").Scan(&one); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from the row, got %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+
+	if err := deleteTestDbFile(); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteTestDbFile()
+
+	comfyMe, err := New(
+		WithPath("test.db"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := comfyMe.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	blockID := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	queued := []*Future[sql.Result]{}
+	for i := 0; i < 10; i++ {
+		queued = append(queued, Submit(comfyMe, func(ctx context.Context, db *sql.DB) (sql.Result, error) {
+			return db.ExecContext(ctx, "INSERT INTO users (name) VALUES (?)", fmt.Sprintf("user%d", i))
+		}))
+	}
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- comfyMe.Shutdown(context.Background())
+	}()
+
+	// New work is refused while draining
+	for refused := false; !refused; {
+		future := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+			return 1, nil
+		})
+		select {
+		case <-future.Done():
+			_, err := future.Get(context.Background())
+			refused = errors.Is(err, ErrClosed)
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	<-comfyMe.WaitForChn(blockID)
+
+	// The queued work was executed
+	for _, future := range queued {
+		if _, err := future.Get(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The WAL was checkpointed
+	if info, err := os.Stat("test.db-wal"); err == nil && info.Size() > 0 {
+		t.Fatalf("expected an empty WAL, got %d bytes", info.Size())
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+
+	comfyMe, err := New(
+		WithMemory(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	queued := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+		return 1, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := comfyMe.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	if _, err := queued.Get(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestCloseRetrying(t *testing.T) {
+
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	// The work waiting for its retry fails with Close and with a Shutdown running out of time
+	for name, closeFn := range map[string]func(c *ComfyDB){
+		"close": func(c *ComfyDB) { c.Close() },
+		"shutdown": func(c *ComfyDB) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			c.Shutdown(ctx)
+		},
+	} {
+		comfyMe, err := New(
+			WithMemory(),
+			WithRetryAttempts(UnlimitedRetries),
+			WithRetryDelay(500*time.Millisecond),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		failed := make(chan struct{})
+		future := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+			select {
+			case <-failed:
+			default:
+				close(failed)
+			}
+			return 0, busy
+		})
+		<-failed
+		time.Sleep(10 * time.Millisecond)
+
+		closeFn(comfyMe)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if _, err := future.Get(ctx); !errors.Is(err, ErrClosed) {
+			t.Fatalf("%s: expected ErrClosed for the work waiting for its retry, got %v", name, err)
+		}
+		cancel()
+
+		// Retried after Close, it fails right away
+		if _, err := comfyMe.Exec("SELECT 1"); !errors.Is(err, ErrClosed) {
+			t.Fatalf("%s: expected ErrClosed, got %v", name, err)
+		}
+	}
+}
+
+func TestResultGarbageCollection(t *testing.T) {
+
+	comfyMe, err := New(
+		WithMemory(),
+		WithResultTTL(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer comfyMe.Close()
+
+	before := len(comfyMe.Pending())
+
+	// Fire and forget never registers anything
+	done := make(chan struct{})
+	comfyMe.Fire(func(db *sql.DB) (interface{}, error) {
+		close(done)
+		return nil, nil
+	})
+	<-done
+	comfyMe.SetMaxOpenConns(1)
+	if pending := len(comfyMe.Pending()); pending != before {
+		t.Fatalf("expected %d pending results, got %d", before, pending)
+	}
+
+	// Forget drops a result nobody will claim
+	forgottenID := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		return nil, nil
+	})
+	if !comfyMe.Forget(forgottenID) {
+		t.Fatal("expected the work to be pending")
+	}
+	if comfyMe.Forget(forgottenID) {
+		t.Fatal("expected the work to be forgotten")
+	}
+
+	// Unclaimed results are swept after the TTL
+	unclaimedID := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		return nil, nil
+	})
+	isPending := func() bool {
+		for _, id := range comfyMe.Pending() {
+			if id == unclaimedID {
+				return true
+			}
+		}
+		return false
+	}
+	if !isPending() {
+		t.Fatal("expected the unclaimed work to be pending")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for isPending() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the unclaimed result to be swept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := comfyMe.WaitFor(unclaimedID); !errors.Is(err, ErrWorkNotFound) {
+		t.Fatalf("expected ErrWorkNotFound, got %v", err)
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+
+	comfyMe, err := New(
+		WithMemory(),
+		WithGroupCommit(16),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfyMe.Close()
+
+	if _, err := comfyMe.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT UNIQUE)"); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	// Queued behind the blocker, they are all executed within the same transaction
+	ids := []uint64{}
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("user%d", i)
+		if i == 5 {
+			name = "user0"
+		}
+		ids = append(ids, comfyMe.NewContext(ContextWithGroupCommit(context.Background()), func(ctx context.Context, db *sql.DB) (interface{}, error) {
+			return db.ExecContext(ctx, "INSERT INTO users (name) VALUES (?)", name)
+		}))
+	}
+	// Work that didn't opt in runs on its own and can hold its own transaction
+	txID := comfyMe.New(func(db *sql.DB) (interface{}, error) {
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "tx"); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		return nil, tx.Commit()
+	})
+	close(release)
+
+	for i, id := range ids {
+		result, err := comfyMe.WaitFor(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, failed := result.(error)
+		if failed != (i == 5) {
+			t.Fatalf("unexpected result for insert %d: %v", i, result)
+		}
+	}
+	if result, err := comfyMe.WaitFor(txID); err != nil || result != nil {
+		t.Fatalf("expected the transaction to be committed, got %v %v", result, err)
+	}
+
+	// Only the failing item was rolled back
+	var count int
+	if err := comfyMe.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Fatalf("expected 10 users, got %d", count)
+	}
+
+	// An explicit batch behaves the same
+	ids = comfyMe.SubmitBatch(
+		func(db *sql.DB) (interface{}, error) {
+			return db.Exec("INSERT INTO users (name) VALUES (?)", "batch1")
+		},
+		func(db *sql.DB) (interface{}, error) {
+			return db.Exec("INSERT INTO users (name) VALUES (?)", "batch1")
+		},
+		func(db *sql.DB) (interface{}, error) {
+			return db.Exec("INSERT INTO users (name) VALUES (?)", "batch2")
+		},
+	)
+	if len(ids) != 3 {
+		t.Fatalf("expected 3 workIDs, got %d", len(ids))
+	}
+	for i, id := range ids {
+		result, err := comfyMe.WaitFor(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, failed := result.(error)
+		if failed != (i == 1) {
+			t.Fatalf("unexpected result for batch item %d: %v", i, result)
+		}
+	}
+	if err := comfyMe.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 12 {
+		t.Fatalf("expected 12 users, got %d", count)
+	}
+
+	// An item ending the transaction fails the batch, the items after it aren't executed
+	ids = comfyMe.SubmitBatch(
+		func(db *sql.DB) (interface{}, error) {
+			return db.Exec("INSERT INTO users (name) VALUES (?)", "before")
+		},
+		func(db *sql.DB) (interface{}, error) {
+			return db.Exec("INSERT OR ROLLBACK INTO users (name) VALUES (?)", "batch2")
+		},
+		func(db *sql.DB) (interface{}, error) {
+			return db.Exec("INSERT INTO users (name) VALUES (?)", "after")
+		},
+	)
+	for i, id := range ids {
+		result, err := comfyMe.WaitFor(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resultErr, _ := result.(error)
+		if aborted := errors.Is(resultErr, ErrBatchAborted); resultErr == nil || aborted != (i != 1) {
+			t.Fatalf("unexpected result for batch item %d: %v", i, result)
+		}
+	}
+	if err := comfyMe.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 12 {
+		t.Fatalf("expected the batch to be rolled back with 12 users, got %d", count)
+	}
+}
+
+func TestSchedulerPause(t *testing.T) {
+
+	comfyMe, err := New(WithMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfyMe.Close()
+
+	first := comfyMe.writer.pause()
+	second := comfyMe.writer.pause()
+	for _, drained := range []<-chan struct{}{first, second} {
+		select {
+		case <-drained:
+		case <-time.After(time.Second):
+			t.Fatal("expected every pause to see the scheduler drained")
+		}
+	}
+
+	future := Submit(comfyMe, func(ctx context.Context, db *sql.DB) (int, error) {
+		return 1, nil
+	})
+
+	// The queue stays paused until every pause is resumed
+	comfyMe.writer.resume()
+	select {
+	case <-future.Done():
+		t.Fatal("expected the work to wait for the last resume")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	comfyMe.writer.resume()
+	if _, err := future.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,175 @@
+// Package comfytest provides helpers to test your comfylite3 migrations.
+package comfytest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/davidroman0O/comfylite3"
+)
+
+// Name of the migration table of the verified database
+const migrationTableName = "_migrations"
+
+// Every verification gets its own in-memory database
+var databases atomic.Uint64
+
+// VerifyMigrations applies each migration in version order on an in-memory database,
+// rolls it back and checks that the schema is the one from before, then applies it again and checks the schema once more.
+// It fails the test with a readable diff of the schemas.
+func VerifyMigrations(t testing.TB, migrations ...comfylite3.Migration) {
+	t.Helper()
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithConnection(fmt.Sprintf("file:comfytest_%d?mode=memory&cache=shared", databases.Add(1))),
+		comfylite3.WithMigrationTableName(migrationTableName),
+		comfylite3.WithMigration(migrations...),
+	)
+	if err != nil {
+		t.Fatalf("comfytest: %v", err)
+		return
+	}
+	defer comfy.Close()
+
+	sorted := make([]comfylite3.Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	ctx := context.Background()
+	for _, migration := range sorted {
+		name := fmt.Sprintf("migration %v %q", migration.Version, migration.Label)
+
+		before, err := snapshot(comfy)
+		if err != nil {
+			t.Fatalf("comfytest: %s: %v", name, err)
+			return
+		}
+
+		if err := comfy.MigrateTo(ctx, migration.Version); err != nil {
+			t.Fatalf("comfytest: %s: up: %v", name, err)
+			return
+		}
+		after, err := snapshot(comfy)
+		if err != nil {
+			t.Fatalf("comfytest: %s: %v", name, err)
+			return
+		}
+
+		if err := comfy.Down(ctx, 1); err != nil {
+			t.Fatalf("comfytest: %s: down: %v", name, err)
+			return
+		}
+		rolledBack, err := snapshot(comfy)
+		if err != nil {
+			t.Fatalf("comfytest: %s: %v", name, err)
+			return
+		}
+		if diff := diff(before, rolledBack); diff != "" {
+			t.Fatalf("comfytest: %s: down doesn't restore the schema from before up (- before up, + after down):\n%s", name, diff)
+			return
+		}
+
+		if err := comfy.MigrateTo(ctx, migration.Version); err != nil {
+			t.Fatalf("comfytest: %s: up again: %v", name, err)
+			return
+		}
+		reapplied, err := snapshot(comfy)
+		if err != nil {
+			t.Fatalf("comfytest: %s: %v", name, err)
+			return
+		}
+		if diff := diff(after, reapplied); diff != "" {
+			t.Fatalf("comfytest: %s: up again doesn't give the same schema (- first up, + second up):\n%s", name, diff)
+			return
+		}
+	}
+}
+
+// Columns of each table, the migration table and the internal tables excluded.
+type schema map[string][]comfylite3.Column
+
+func snapshot(comfy *comfylite3.ComfyDB) (schema, error) {
+	tables, err := comfy.ShowTables()
+	if err != nil {
+		return nil, err
+	}
+	s := schema{}
+	for _, table := range tables {
+		if table == migrationTableName || strings.HasPrefix(table, "sqlite_") {
+			continue
+		}
+		columns, err := comfy.ShowColumns(table)
+		if err != nil {
+			return nil, err
+		}
+		s[table] = columns
+	}
+	return s, nil
+}
+
+// Lines describing the tables and columns only in one of the schemas, or different, empty when equal.
+func diff(expected, actual schema) string {
+	tables := map[string]bool{}
+	for table := range expected {
+		tables[table] = true
+	}
+	for table := range actual {
+		tables[table] = true
+	}
+	names := make([]string, 0, len(tables))
+	for table := range tables {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+
+	lines := []string{}
+	for _, table := range names {
+		expectedColumns, inExpected := expected[table]
+		actualColumns, inActual := actual[table]
+		switch {
+		case !inActual:
+			lines = append(lines, fmt.Sprintf("- table %s", table))
+		case !inExpected:
+			lines = append(lines, fmt.Sprintf("+ table %s", table))
+		default:
+			expectedLines := columnLines(expectedColumns)
+			actualLines := columnLines(actualColumns)
+			if strings.Join(expectedLines, "\n") == strings.Join(actualLines, "\n") {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("  table %s", table))
+			for _, line := range expectedLines {
+				lines = append(lines, "-   "+line)
+			}
+			for _, line := range actualLines {
+				lines = append(lines, "+   "+line)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// One line per column with everything that matters.
+func columnLines(columns []comfylite3.Column) []string {
+	lines := make([]string, len(columns))
+	for i, column := range columns {
+		line := fmt.Sprintf("%s %s", column.Name, column.Type)
+		if column.NotNull {
+			line += " NOT NULL"
+		}
+		if column.DfltValue != nil {
+			line += " DEFAULT " + *column.DfltValue
+		}
+		if column.Pk {
+			line += " PRIMARY KEY"
+		}
+		lines[i] = line
+	}
+	return lines
+}
@@ -0,0 +1,69 @@
+package comfytest
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/davidroman0O/comfylite3"
+)
+
+// Records the failure instead of failing the test
+type recorder struct {
+	testing.TB
+	failure string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...any) {
+	r.failure = fmt.Sprintf(format, args...)
+}
+
+func exec(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestVerifyMigrations(t *testing.T) {
+
+	genesis := comfylite3.NewMigration(1, "genesis",
+		exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL)"),
+		exec("DROP TABLE users"),
+	)
+
+	VerifyMigrations(t,
+		comfylite3.NewMigration(2, "email",
+			exec("ALTER TABLE users ADD COLUMN email TEXT DEFAULT ''"),
+			exec("ALTER TABLE users DROP COLUMN email"),
+		),
+		genesis,
+	)
+
+	// The down forgets the column
+	r := &recorder{TB: t}
+	VerifyMigrations(r,
+		genesis,
+		comfylite3.NewMigration(2, "email",
+			exec("ALTER TABLE users ADD COLUMN email TEXT", "CREATE TABLE logs (id INTEGER PRIMARY KEY)"),
+			exec("DROP TABLE logs"),
+		),
+	)
+	for _, expected := range []string{
+		`migration 2 "email": down doesn't restore the schema`,
+		"  table users",
+		"-   name TEXT NOT NULL",
+		"+   email TEXT",
+	} {
+		if !strings.Contains(r.failure, expected) {
+			t.Fatalf("expected the failure to contain %q, got:\n%s", expected, r.failure)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/davidroman0O/comfylite3"
+)
+
+// Fill a table with enough rows to span many pages
+func fillBackup(t *testing.T, comfy *comfylite3.ComfyDB) {
+	t.Helper()
+	_, err := comfylite3.Submit(comfy, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		if _, err := db.ExecContext(ctx, "CREATE TABLE items (id INTEGER PRIMARY KEY, payload TEXT)"); err != nil {
+			return struct{}{}, err
+		}
+		for range 2000 {
+			if _, err := db.ExecContext(ctx, "INSERT INTO items (payload) VALUES (?)", strings.Repeat("comfy", 40)); err != nil {
+				return struct{}{}, err
+			}
+		}
+		return struct{}{}, nil
+	}).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countItems(t *testing.T, comfy *comfylite3.ComfyDB) int {
+	t.Helper()
+	count, err := comfylite3.SubmitRead(comfy, func(ctx context.Context, db *sql.DB) (int, error) {
+		var count int
+		return count, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM items").Scan(&count)
+	}).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestBackup(t *testing.T) {
+
+	sources := map[string]comfylite3.ComfyOption{
+		"file":   comfylite3.WithPath(filepath.Join(t.TempDir(), "source.db")),
+		"memory": comfylite3.WithConnection("file:backup?mode=memory&cache=shared"),
+	}
+
+	for name, option := range sources {
+		t.Run(name, func(t *testing.T) {
+			comfy, err := comfylite3.New(option)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer comfy.Close()
+
+			fillBackup(t, comfy)
+
+			steps := 0
+			dest := filepath.Join(t.TempDir(), "backup.db")
+			err = comfy.Backup(context.Background(), dest, comfylite3.BackupOptions{
+				PagesPerStep: 10,
+				Progress: func(remaining, total int) {
+					steps++
+					if steps != 1 {
+						return
+					}
+					// Work goes on between the steps and is part of the copy
+					if _, err := comfy.Exec("INSERT INTO items (payload) VALUES ('during')"); err != nil {
+						t.Error(err)
+					}
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if steps < 2 {
+				t.Fatalf("expected the backup to take several steps, got %d", steps)
+			}
+
+			copied, err := comfylite3.New(comfylite3.WithPath(dest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer copied.Close()
+
+			if expected, got := countItems(t, comfy), countItems(t, copied); expected != 2001 || got != expected {
+				t.Fatalf("expected %d items in the backup, got %d", expected, got)
+			}
+		})
+	}
+}
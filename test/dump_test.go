@@ -0,0 +1,199 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/davidroman0O/comfylite3"
+)
+
+// Schema with the values a dump has to write back as they were
+var dumpMigration = comfylite3.NewSQLMigration(1, "dump", `
+CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, score REAL, avatar BLOB, born DATETIME, upper_name TEXT GENERATED ALWAYS AS (upper(name)) VIRTUAL);
+CREATE TABLE settings (key TEXT PRIMARY KEY, value ANY) STRICT, WITHOUT ROWID;
+CREATE INDEX users_name ON users (name);
+CREATE VIEW scores AS SELECT name, score FROM users;
+CREATE TRIGGER users_setting AFTER INSERT ON users BEGIN INSERT OR REPLACE INTO settings (key, value) VALUES ('last', NEW.name); END;
+`, "")
+
+func TestDumpLoad(t *testing.T) {
+
+	source, err := comfylite3.New(
+		comfylite3.WithConnection("file:dump_source?mode=memory&cache=shared"),
+		comfylite3.WithMigration(dumpMigration),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	if err := source.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = comfylite3.Submit(source, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		for i := range 7 {
+			if _, err := db.ExecContext(ctx,
+				"INSERT INTO users (name, score, avatar, born) VALUES (?, ?, ?, '2024-01-02 03:04:05')",
+				fmt.Sprintf("it's user %d\nsecond line", i), float64(i), []byte{0, byte(i), 0xff}); err != nil {
+				return struct{}{}, err
+			}
+		}
+		_, err := db.ExecContext(ctx, "INSERT INTO users (name) VALUES (NULL)")
+		return struct{}{}, err
+	}).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dump bytes.Buffer
+	if err := source.Dump(context.Background(), &dump, comfylite3.DumpOptions{Data: true, ChunkSize: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := comfylite3.New(
+		comfylite3.WithConnection("file:dump_target?mode=memory&cache=shared"),
+		comfylite3.WithMigration(dumpMigration),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	if err := target.Load(context.Background(), bytes.NewReader(dump.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	// The migrations are part of the dump
+	expectIndex(t, target, 1)
+
+	diff, err := comfylite3.DiffSchema(context.Background(), source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Differences) != 0 {
+		t.Fatalf("expected the same schema, got %+v", diff.Differences)
+	}
+
+	var again bytes.Buffer
+	if err := target.Dump(context.Background(), &again, comfylite3.DumpOptions{Data: true}); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != dump.String() {
+		t.Fatalf("expected the loaded database to dump the same\n%s\ngot\n%s", dump.String(), again.String())
+	}
+
+	if err := target.Load(context.Background(), bytes.NewReader(dump.Bytes())); !errors.Is(err, comfylite3.ErrDatabaseNotEmpty) {
+		t.Fatalf("expected ErrDatabaseNotEmpty, got %v", err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithConnection("file:load_invalid?mode=memory&cache=shared"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	dump := "BEGIN TRANSACTION;\nCREATE TABLE half (id INTEGER PRIMARY KEY);\nINSERT INTO missing VALUES(1);\nCOMMIT;\n"
+	if err := comfy.Load(context.Background(), bytes.NewBufferString(dump)); err == nil {
+		t.Fatal("expected the invalid dump to fail")
+	}
+
+	tables, err := comfy.ShowTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if table == "half" {
+			t.Fatalf("expected the dump to be rolled back, got %v", tables)
+		}
+	}
+
+	if err := comfy.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDumpVirtualTable(t *testing.T) {
+
+	// fts4 is built in the driver, its shadow tables are created along with the virtual table
+	ftsMigration := comfylite3.NewSQLMigration(1, "fts", `
+	CREATE VIRTUAL TABLE notes USING fts4(body);
+	INSERT INTO notes (docid, body) VALUES (42, 'comfy notes');
+	CREATE VIRTUAL TABLE posts_fts USING fts4(content="posts", title);
+	CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT);
+	INSERT INTO posts (id, title) VALUES (7, 'comfy posts');
+	INSERT INTO posts_fts (posts_fts) VALUES ('rebuild');
+	`, "")
+
+	source, err := comfylite3.New(
+		comfylite3.WithConnection("file:dump_fts_source?mode=memory&cache=shared"),
+		comfylite3.WithMigration(ftsMigration),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	if err := source.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var dump bytes.Buffer
+	if err := source.Dump(context.Background(), &dump, comfylite3.DumpOptions{Data: true}); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(dump.Bytes(), []byte("notes_content")) {
+		t.Fatalf("expected the shadow tables to be left out, got\n%s", dump.String())
+	}
+
+	target, err := comfylite3.New(
+		comfylite3.WithConnection("file:dump_fts_target?mode=memory&cache=shared"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	if err := target.Load(context.Background(), bytes.NewReader(dump.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rows survive the round trip and the index still works
+	_, err = comfylite3.Submit(target, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		var docid int
+		if err := db.QueryRowContext(ctx, "SELECT docid FROM notes WHERE notes MATCH 'comfy'").Scan(&docid); err != nil {
+			return struct{}{}, fmt.Errorf("the note: %w", err)
+		}
+		if docid != 42 {
+			return struct{}{}, fmt.Errorf("expected the docid 42, got %d", docid)
+		}
+		var id int
+		if err := db.QueryRowContext(ctx, "SELECT docid FROM posts_fts WHERE posts_fts MATCH 'posts'").Scan(&id); err != nil {
+			return struct{}{}, fmt.Errorf("the post: %w", err)
+		}
+		if id != 7 {
+			return struct{}{}, fmt.Errorf("expected the post 7, got %d", id)
+		}
+		return struct{}{}, nil
+	}).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without content there is nothing to dump
+	if _, err := source.Exec("CREATE VIRTUAL TABLE bare USING fts4(content='', body)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Dump(context.Background(), &bytes.Buffer{}, comfylite3.DumpOptions{Data: true}); err == nil {
+		t.Fatal("expected the contentless table to fail the dump")
+	}
+}
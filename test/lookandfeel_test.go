@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/davidroman0O/comfylite3"
+	"github.com/davidroman0O/comfylite3/comfytest"
 )
 
 // All migrations of the memory database
@@ -267,6 +268,18 @@ func TestMigration(t *testing.T) {
 					if col.Type != "INTEGER" {
 						t.Fatalf("expected INTEGER, got %s", col.Type)
 					}
+				case "checksum":
+					if col.Type != "TEXT" {
+						t.Fatalf("expected TEXT, got %s", col.Type)
+					}
+				case "applied_at":
+					if col.Type != "DATETIME" {
+						t.Fatalf("expected DATETIME, got %s", col.Type)
+					}
+				case "duration":
+					if col.Type != "INTEGER" {
+						t.Fatalf("expected INTEGER, got %s", col.Type)
+					}
 				default:
 					t.Fatalf("unexpected column %s", col.Name)
 				}
@@ -287,6 +300,10 @@ func TestMigration(t *testing.T) {
 	}
 }
 
+func TestMigrationRoundtrip(t *testing.T) {
+	comfytest.VerifyMigrations(t, memoryMigrations...)
+}
+
 func TestMemory(t *testing.T) {
 
 	var superComfy *comfylite3.ComfyDB
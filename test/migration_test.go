@@ -0,0 +1,1044 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/davidroman0O/comfylite3"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration creating then dropping a table
+func tableMigration(version uint, table string) comfylite3.Migration {
+	return comfylite3.NewMigration(
+		version,
+		table,
+		func(tx *sql.Tx) error {
+			_, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (id INTEGER PRIMARY KEY)", table))
+			return err
+		},
+		func(tx *sql.Tx) error {
+			_, err := tx.Exec(fmt.Sprintf("DROP TABLE %s", table))
+			return err
+		},
+	)
+}
+
+// Migration failing to apply
+func brokenMigration(version uint, label string) comfylite3.Migration {
+	return comfylite3.NewMigration(
+		version,
+		label,
+		func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE broken (")
+			return err
+		},
+		func(tx *sql.Tx) error {
+			return nil
+		},
+	)
+}
+
+func expectIndex(t *testing.T, comfy *comfylite3.ComfyDB, expected ...uint) {
+	t.Helper()
+	index, err := comfy.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(index) != fmt.Sprint(expected) {
+		t.Fatalf("expected applied versions %v, got %v", expected, index)
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithMemory(),
+		comfylite3.WithMigration(
+			tableMigration(1, "one"),
+			tableMigration(2, "two"),
+			tableMigration(3, "three"),
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	if err := comfy.MigrateTo(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, comfy, 1, 2)
+
+	if err := comfy.MigrateTo(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, comfy, 1, 2, 3)
+
+	if err := comfy.MigrateTo(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, comfy, 1)
+
+	tables, err := comfy.ShowTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if table == "two" || table == "three" {
+			t.Fatalf("expected table %s to be dropped", table)
+		}
+	}
+
+	if err := comfy.MigrateTo(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, comfy)
+
+	if err := comfy.MigrateTo(context.Background(), 4); !errors.Is(err, comfylite3.ErrMigrationNotFound) {
+		t.Fatalf("expected ErrMigrationNotFound, got %v", err)
+	}
+}
+
+func TestTransactionPerMigration(t *testing.T) {
+
+	migrations := []comfylite3.Migration{
+		tableMigration(1, "one"),
+		tableMigration(2, "two"),
+		brokenMigration(3, "broken"),
+		tableMigration(4, "four"),
+	}
+
+	// A single transaction for all of them, nothing is applied
+	comfy, err := comfylite3.New(
+		comfylite3.WithMemory(),
+		comfylite3.WithMigration(migrations...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = comfy.Up(context.Background())
+	var migrationErr *comfylite3.MigrationError
+	if !errors.As(err, &migrationErr) {
+		t.Fatalf("expected a MigrationError, got %v", err)
+	}
+	if migrationErr.Migration.Label != "broken" || migrationErr.Direction != comfylite3.MigrationUp {
+		t.Fatalf("unexpected failing migration %v", err)
+	}
+	expectIndex(t, comfy)
+	comfy.Close()
+
+	// One transaction per migration, the ones before the failure stay applied
+	comfy, err = comfylite3.New(
+		comfylite3.WithConnection("file:per_migration?mode=memory&cache=shared"),
+		comfylite3.WithMigration(migrations...),
+		comfylite3.WithTransactionPerMigration(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	err = comfy.Up(context.Background())
+	if !errors.As(err, &migrationErr) || migrationErr.Migration.Label != "broken" {
+		t.Fatalf("expected the broken migration to fail, got %v", err)
+	}
+	expectIndex(t, comfy, 1, 2)
+}
+
+func TestDownSparseVersions(t *testing.T) {
+
+	for name, versions := range map[string][]uint{
+		"sparse":    {10, 20, 30},
+		"timestamp": {20240101093000, 20240215180000, 20241120071500},
+	} {
+		t.Run(name, func(t *testing.T) {
+			comfy, err := comfylite3.New(
+				comfylite3.WithConnection(fmt.Sprintf("file:%s?mode=memory&cache=shared", name)),
+				comfylite3.WithMigration(
+					// Registered out of order on purpose
+					tableMigration(versions[2], "third"),
+					tableMigration(versions[0], "first"),
+					tableMigration(versions[1], "second"),
+				),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer comfy.Close()
+
+			if err := comfy.Up(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			expectIndex(t, comfy, versions...)
+
+			// Each Down rolls back the last applied migration, not the one at its position
+			if err := comfy.Down(context.Background(), 1); err != nil {
+				t.Fatal(err)
+			}
+			expectIndex(t, comfy, versions[0], versions[1])
+
+			tables, err := comfy.ShowTables()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, table := range tables {
+				if table == "third" {
+					t.Fatal("expected table third to be dropped")
+				}
+			}
+
+			if err := comfy.Down(context.Background(), 5); err != nil {
+				t.Fatal(err)
+			}
+			expectIndex(t, comfy)
+
+			if err := comfy.Up(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			expectIndex(t, comfy, versions...)
+		})
+	}
+}
+
+func TestDownUnregisteredVersion(t *testing.T) {
+
+	const conn = "file:unregistered?mode=memory&cache=shared"
+
+	// Keeps the shared memory database alive
+	applied, err := comfylite3.New(
+		comfylite3.WithConnection(conn),
+		comfylite3.WithMigration(tableMigration(10, "first"), tableMigration(20, "second")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer applied.Close()
+
+	if err := applied.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithConnection(conn),
+		comfylite3.WithMigration(tableMigration(10, "first")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	if err := comfy.Down(context.Background(), 1); !errors.Is(err, comfylite3.ErrMigrationNotFound) {
+		t.Fatalf("expected ErrMigrationNotFound, got %v", err)
+	}
+	expectIndex(t, comfy, 10, 20)
+}
+
+func TestMigrationsFS(t *testing.T) {
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithMemory(),
+		comfylite3.WithMigrationsFS(migrationFiles, "migrations"),
+		// Mixed with the SQL files
+		comfylite3.WithMigration(tableMigration(2, "orders")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	if err := comfy.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, comfy, 1, 2, 3)
+
+	migrations, err := comfy.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrations[0].Label != "genesis" || migrations[2].Label != "user_email" {
+		t.Fatalf("unexpected labels %v and %v", migrations[0].Label, migrations[2].Label)
+	}
+
+	columns, err := comfy.ShowColumns("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 3 || columns[2].Name != "email" {
+		t.Fatalf("expected the email column, got %v", columns)
+	}
+
+	if err := comfy.Down(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, comfy)
+
+	tables, err := comfy.ShowTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if table == "users" || table == "products" || table == "orders" {
+			t.Fatalf("expected table %s to be dropped", table)
+		}
+	}
+}
+
+func TestMigrationsFSInvalid(t *testing.T) {
+
+	for name, files := range map[string]fstest.MapFS{
+		"missing down": {
+			"sql/0001_genesis.up.sql": {Data: []byte("CREATE TABLE users (id INTEGER)")},
+		},
+		"mismatched labels": {
+			"sql/0001_genesis.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER)")},
+			"sql/0001_initial.down.sql": {Data: []byte("DROP TABLE users")},
+		},
+		"duplicated version": {
+			"sql/0002_orders.up.sql":   {Data: []byte("CREATE TABLE orders (id INTEGER)")},
+			"sql/0002_orders.down.sql": {Data: []byte("DROP TABLE orders")},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := comfylite3.New(
+				comfylite3.WithMemory(),
+				comfylite3.WithMigration(tableMigration(2, "orders")),
+				comfylite3.WithMigrationsFS(files, "sql"),
+			)
+			if !errors.Is(err, comfylite3.ErrInvalidMigration) {
+				t.Fatalf("expected ErrInvalidMigration, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMigrationDrift(t *testing.T) {
+
+	const conn = "file:drift?mode=memory&cache=shared"
+
+	genesis := func(up string) fstest.MapFS {
+		return fstest.MapFS{
+			"sql/0001_genesis.up.sql":   {Data: []byte(up)},
+			"sql/0001_genesis.down.sql": {Data: []byte("DROP TABLE users")},
+		}
+	}
+
+	// Keeps the shared memory database alive
+	applied, err := comfylite3.New(
+		comfylite3.WithConnection(conn),
+		comfylite3.WithMigrationsFS(genesis("CREATE TABLE users (id INTEGER PRIMARY KEY)"), "sql"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer applied.Close()
+
+	if err := applied.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone edited the applied file
+	edited, err := comfylite3.New(
+		comfylite3.WithConnection(conn),
+		comfylite3.WithMigrationsFS(genesis("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"), "sql"),
+		comfylite3.WithMigration(tableMigration(2, "orders")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edited.Close()
+
+	err = edited.Up(context.Background())
+	var drift comfylite3.MigrationDrift
+	if !errors.Is(err, comfylite3.ErrMigrationDrift) || !errors.As(err, &drift) {
+		t.Fatalf("expected a MigrationDrift, got %v", err)
+	}
+	if drift.Migration.Version != 1 || drift.Checksum == drift.Migration.Checksum {
+		t.Fatalf("unexpected drift %v", drift)
+	}
+	expectIndex(t, edited, 1)
+
+	// Someone renamed the applied migration, the handler only warns
+	drifts := []comfylite3.MigrationDrift{}
+	renamed, err := comfylite3.New(
+		comfylite3.WithConnection(conn),
+		comfylite3.WithMigration(tableMigration(1, "users"), tableMigration(2, "orders")),
+		comfylite3.WithMigrationDriftHandler(func(drift comfylite3.MigrationDrift) {
+			drifts = append(drifts, drift)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer renamed.Close()
+
+	if err := renamed.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 1 || drifts[0].Label != "genesis" || drifts[0].Migration.Label != "users" {
+		t.Fatalf("unexpected drifts %v", drifts)
+	}
+	expectIndex(t, renamed, 1, 2)
+}
+
+func TestMigrationTableUpgrade(t *testing.T) {
+
+	const conn = "file:upgrade?mode=memory&cache=shared"
+
+	// A migration table from before the checksums, it keeps the shared memory database alive
+	legacy, err := sql.Open("sqlite3", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacy.Close()
+	if _, err := legacy.Exec(`
+		CREATE TABLE _migrations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			version INTEGER UNIQUE NOT NULL,
+			description VARCHAR(255) UNIQUE NOT NULL
+		);
+		CREATE TABLE one (id INTEGER PRIMARY KEY);
+		INSERT INTO _migrations (version, description) VALUES (1, 'one');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithConnection(conn),
+		comfylite3.WithMigration(tableMigration(1, "one"), tableMigration(2, "two")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	if err := comfy.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, comfy, 1, 2)
+
+	var appliedAt sql.NullTime
+	var duration sql.NullInt64
+	if err := comfy.QueryRow("SELECT applied_at, duration FROM _migrations WHERE version = 1").Scan(&appliedAt, &duration); err != nil {
+		t.Fatal(err)
+	}
+	if appliedAt.Valid || duration.Valid {
+		t.Fatal("expected the legacy migration to have no applied_at nor duration")
+	}
+	if err := comfy.QueryRow("SELECT applied_at, duration FROM _migrations WHERE version = 2").Scan(&appliedAt, &duration); err != nil {
+		t.Fatal(err)
+	}
+	if !appliedAt.Valid || appliedAt.Time.IsZero() || !duration.Valid {
+		t.Fatal("expected the new migration to record applied_at and duration")
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+
+	const conn = "file:status?mode=memory&cache=shared"
+
+	// Keeps the shared memory database alive
+	applied, err := comfylite3.New(
+		comfylite3.WithConnection(conn),
+		comfylite3.WithMigration(tableMigration(1, "one"), tableMigration(3, "three"), tableMigration(4, "four")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer applied.Close()
+
+	if err := applied.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithConnection(conn),
+		comfylite3.WithMigration(tableMigration(1, "one"), tableMigration(2, "two"), tableMigration(3, "three"), tableMigration(5, "five")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	statuses, err := comfy.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		version uint
+		label   string
+		state   comfylite3.MigrationState
+	}{
+		{1, "one", comfylite3.MigrationApplied},
+		{2, "two", comfylite3.MigrationOutOfOrder},
+		{3, "three", comfylite3.MigrationApplied},
+		{4, "four", comfylite3.MigrationMissing},
+		{5, "five", comfylite3.MigrationPending},
+	}
+	if len(statuses) != len(expected) {
+		t.Fatalf("expected %d statuses, got %v", len(expected), statuses)
+	}
+	for i, status := range statuses {
+		if status.Migration.Version != expected[i].version || status.Migration.Label != expected[i].label || status.State != expected[i].state {
+			t.Fatalf("expected %v %s %v, got %v %s %v", expected[i].version, expected[i].label, expected[i].state, status.Migration.Version, status.Migration.Label, status.State)
+		}
+		applied := status.State == comfylite3.MigrationApplied || status.State == comfylite3.MigrationMissing
+		if applied == status.AppliedAt.IsZero() {
+			t.Fatalf("unexpected applied_at %v for version %v", status.AppliedAt, status.Migration.Version)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithMemory(),
+		comfylite3.WithMigration(
+			tableMigration(1, "one"),
+			tableMigration(2, "two"),
+			comfylite3.NewMigration(3, "index",
+				func(tx *sql.Tx) error {
+					if _, err := tx.Exec("ALTER TABLE two ADD COLUMN name TEXT"); err != nil {
+						return err
+					}
+					_, err := tx.Exec("CREATE INDEX two_name ON two(name)")
+					return err
+				},
+				func(tx *sql.Tx) error {
+					if _, err := tx.Exec("DROP INDEX two_name"); err != nil {
+						return err
+					}
+					_, err := tx.Exec("ALTER TABLE two DROP COLUMN name")
+					return err
+				},
+			),
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	if err := comfy.MigrateTo(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := comfy.DryRunUp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Steps) != 2 || report.Steps[0].Migration.Label != "two" || report.Steps[1].Migration.Label != "index" {
+		t.Fatalf("unexpected steps %v", report.Steps)
+	}
+	if fmt.Sprint(report.Steps[1].Statements) != "[ALTER TABLE two ADD COLUMN name TEXT CREATE INDEX two_name ON two(name)]" {
+		t.Fatalf("unexpected statements %v", report.Steps[1].Statements)
+	}
+
+	expected := []comfylite3.SchemaChange{
+		{Type: "index", Name: "two_name", After: "CREATE INDEX two_name ON two(name)"},
+		{Type: "table", Name: "two", After: "CREATE TABLE two (id INTEGER PRIMARY KEY, name TEXT)"},
+	}
+	if fmt.Sprint(report.Changes) != fmt.Sprint(expected) {
+		t.Fatalf("expected changes %v, got %v", expected, report.Changes)
+	}
+
+	// Nothing happened for real
+	expectIndex(t, comfy, 1)
+	tables, err := comfy.ShowTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if table == "two" {
+			t.Fatal("expected table two not to exist")
+		}
+	}
+
+	report, err = comfy.DryRunDown(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 1 || report.Changes[0].Name != "one" || report.Changes[0].After != "" {
+		t.Fatalf("expected table one to be dropped, got %v", report.Changes)
+	}
+	expectIndex(t, comfy, 1)
+}
+
+func TestMigrationLock(t *testing.T) {
+
+	path := t.TempDir() + "/lock.db"
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := comfylite3.NewMigration(1, "slow",
+		func(tx *sql.Tx) error {
+			close(started)
+			<-release
+			_, err := tx.Exec("CREATE TABLE slow (id INTEGER PRIMARY KEY)")
+			return err
+		},
+		func(tx *sql.Tx) error {
+			_, err := tx.Exec("DROP TABLE slow")
+			return err
+		},
+	)
+
+	first, err := comfylite3.New(
+		comfylite3.WithPath(path),
+		comfylite3.WithMigration(slow),
+		comfylite3.WithMigrationLock(time.Minute, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	// Another process with the same migrations
+	second, err := comfylite3.New(
+		comfylite3.WithPath(path),
+		comfylite3.WithMigration(tableMigration(1, "slow")),
+		comfylite3.WithMigrationLock(time.Minute, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	migrated := make(chan error)
+	go func() {
+		migrated <- first.Up(context.Background())
+	}()
+	<-started
+
+	if err := second.Up(context.Background()); !errors.Is(err, comfylite3.ErrMigrationInProgress) {
+		t.Fatalf("expected ErrMigrationInProgress, got %v", err)
+	}
+
+	close(release)
+	if err := <-migrated; err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock is released, nothing left to apply
+	if err := second.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, second, 1)
+}
+
+func TestMigrationLockLease(t *testing.T) {
+
+	path := t.TempDir() + "/lock.db"
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := comfylite3.NewMigration(1, "slow",
+		func(tx *sql.Tx) error {
+			close(started)
+			<-release
+			_, err := tx.Exec("CREATE TABLE slow (id INTEGER PRIMARY KEY)")
+			return err
+		},
+		func(tx *sql.Tx) error {
+			_, err := tx.Exec("DROP TABLE slow")
+			return err
+		},
+	)
+
+	// The migration takes longer than the lease
+	first, err := comfylite3.New(
+		comfylite3.WithPath(path),
+		comfylite3.WithMigration(slow),
+		comfylite3.WithMigrationLock(150*time.Millisecond, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := comfylite3.New(
+		comfylite3.WithPath(path),
+		comfylite3.WithMigration(tableMigration(1, "slow")),
+		comfylite3.WithMigrationLock(150*time.Millisecond, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	migrated := make(chan error)
+	go func() {
+		migrated <- first.Up(context.Background())
+	}()
+	<-started
+	time.Sleep(400 * time.Millisecond)
+
+	if err := second.Up(context.Background()); !errors.Is(err, comfylite3.ErrMigrationInProgress) {
+		t.Fatalf("expected the lock to be kept past the lease, got %v", err)
+	}
+
+	close(release)
+	if err := <-migrated; err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, second, 1)
+}
+
+func TestMigrationLockWait(t *testing.T) {
+
+	path := t.TempDir() + "/lock.db"
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithPath(path),
+		comfylite3.WithMigration(tableMigration(1, "one")),
+		comfylite3.WithMigrationLock(time.Minute, 5*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	// A crashed process left an expired lock behind, another one holds it for a moment
+	if _, err := comfy.Exec(`
+		CREATE TABLE _migrations_lock (id INTEGER PRIMARY KEY CHECK (id = 1), owner TEXT NOT NULL, expires_at INTEGER NOT NULL);
+		INSERT INTO _migrations_lock (id, owner, expires_at) VALUES (1, 'crashed', ?);
+	`, time.Now().Add(-time.Second).UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	if err := comfy.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := comfy.Down(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := comfy.Exec("INSERT INTO _migrations_lock (id, owner, expires_at) VALUES (1, 'other', ?)", time.Now().Add(time.Minute).UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		comfy.Exec("DELETE FROM _migrations_lock")
+	}()
+
+	start := time.Now()
+	if err := comfy.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Fatal("expected Up to wait for the lock")
+	}
+	expectIndex(t, comfy, 1)
+}
+
+func TestMigrationHooks(t *testing.T) {
+
+	events := []string{}
+	abort := false
+	errAbort := errors.New("not now")
+	comfy, err := comfylite3.New(
+		comfylite3.WithMemory(),
+		comfylite3.WithMigration(tableMigration(1, "one"), tableMigration(2, "two"), brokenMigration(3, "broken")),
+		comfylite3.WithTransactionPerMigration(),
+		comfylite3.WithMigrationHooks(comfylite3.MigrationHooks{
+			BeforeMigration: func(ctx context.Context, event comfylite3.MigrationEvent) error {
+				events = append(events, fmt.Sprintf("before %s %v", event.Migration.Label, event.Direction))
+				if abort && event.Migration.Label == "two" {
+					return errAbort
+				}
+				return nil
+			},
+			AfterMigration: func(ctx context.Context, event comfylite3.MigrationEvent) error {
+				if event.Duration <= 0 {
+					t.Errorf("expected a duration for %s", event.Migration.Label)
+				}
+				events = append(events, fmt.Sprintf("after %s %v", event.Migration.Label, event.Direction))
+				return nil
+			},
+			OnMigrationError: func(ctx context.Context, event comfylite3.MigrationEvent) {
+				events = append(events, fmt.Sprintf("error %s %v", event.Migration.Label, event.Direction))
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	if err := comfy.MigrateTo(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := comfy.Down(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	abort = true
+	err = comfy.Up(context.Background())
+	if !errors.Is(err, comfylite3.ErrMigrationAborted) || !errors.Is(err, errAbort) {
+		t.Fatalf("expected the migration to be aborted, got %v", err)
+	}
+	expectIndex(t, comfy, 1)
+
+	abort = false
+	if err := comfy.Up(context.Background()); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+	expectIndex(t, comfy, 1, 2)
+
+	expected := []string{
+		"before one up", "after one up",
+		"before two up", "after two up",
+		"before two down", "after two down",
+		"before two up", "error two up",
+		"before two up", "after two up",
+		"before broken up", "error broken up",
+	}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestMigrationHooksSingleTransaction(t *testing.T) {
+
+	events := []string{}
+	errAfter := errors.New("after")
+	comfy, err := comfylite3.New(
+		comfylite3.WithMemory(),
+		comfylite3.WithMigration(tableMigration(1, "one"), tableMigration(2, "two"), brokenMigration(3, "broken")),
+		comfylite3.WithMigrationHooks(comfylite3.MigrationHooks{
+			BeforeMigration: func(ctx context.Context, event comfylite3.MigrationEvent) error {
+				events = append(events, fmt.Sprintf("before %s", event.Migration.Label))
+				return nil
+			},
+			AfterMigration: func(ctx context.Context, event comfylite3.MigrationEvent) error {
+				events = append(events, fmt.Sprintf("after %s", event.Migration.Label))
+				if event.Migration.Label == "one" {
+					return errAfter
+				}
+				return nil
+			},
+			OnMigrationError: func(ctx context.Context, event comfylite3.MigrationEvent) {
+				events = append(events, fmt.Sprintf("error %s", event.Migration.Label))
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	// Rolled back with the broken migration, nothing is reported as migrated
+	if err := comfy.Up(context.Background()); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+	expectIndex(t, comfy)
+
+	// The hooks come after the commit, the migrations stay applied
+	err = comfy.MigrateTo(context.Background(), 2)
+	if !errors.Is(err, comfylite3.ErrMigrationAborted) || !errors.Is(err, errAfter) {
+		t.Fatalf("expected the hook error, got %v", err)
+	}
+	expectIndex(t, comfy, 1, 2)
+
+	expected := []string{
+		"before one", "before two", "before broken", "error broken",
+		"before one", "before two", "after one", "error one",
+	}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+
+	source, err := comfylite3.New(
+		comfylite3.WithMemory(),
+		comfylite3.WithMigration(tableMigration(1, "one"), tableMigration(2, "two")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	if err := source.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var baseline strings.Builder
+	if err := source.WriteBaseline(context.Background(), &baseline); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(baseline.String(), "_migrations") {
+		t.Fatalf("expected the migration table to be left out, got %s", baseline.String())
+	}
+
+	// The retired migrations would fail if they were applied
+	comfy, err := comfylite3.New(
+		comfylite3.WithConnection("file:baseline?mode=memory&cache=shared"),
+		comfylite3.WithBaseline(comfylite3.NewSQLMigration(3, "baseline", baseline.String(), "")),
+		comfylite3.WithMigration(brokenMigration(1, "one"), brokenMigration(2, "two"), tableMigration(4, "four")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	if err := comfy.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, comfy, 1, 2, 3, 4)
+
+	tables, err := comfy.ShowTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"one", "two", "four"} {
+		if !slices.Contains(tables, table) {
+			t.Fatalf("expected table %s, got %v", table, tables)
+		}
+	}
+
+	statuses, err := comfy.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.State != comfylite3.MigrationApplied {
+			t.Fatalf("expected version %v to be applied, got %v", status.Migration.Version, status.State)
+		}
+	}
+
+	if err := comfy.Down(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := comfy.Down(context.Background(), 1); !errors.Is(err, comfylite3.ErrMigrationNotFound) {
+		t.Fatalf("expected the baseline not to roll back, got %v", err)
+	}
+}
+
+func TestWriteBaselineFrom(t *testing.T) {
+	path := t.TempDir() + "/plain.db"
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	readOnly, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+
+	var baseline strings.Builder
+	if err := comfylite3.WriteBaselineFrom(context.Background(), readOnly, "_migrations", &baseline); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(baseline.String(), "version 0") || !strings.Contains(baseline.String(), "CREATE TABLE items") {
+		t.Fatalf("expected the items table at version 0, got %s", baseline.String())
+	}
+
+	// The database is left as it was
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	var journal string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journal); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 1 || journal != "delete" {
+		t.Fatalf("expected the database untouched, got %d tables in %s mode", tables, journal)
+	}
+}
+
+func TestBaselineVirtualTable(t *testing.T) {
+
+	source, err := comfylite3.New(
+		comfylite3.WithConnection("file:baseline_fts_source?mode=memory&cache=shared"),
+		comfylite3.WithMigration(comfylite3.NewSQLMigration(1, "fts", "CREATE VIRTUAL TABLE notes USING fts4(body)", "")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	if err := source.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var baseline strings.Builder
+	if err := source.WriteBaseline(context.Background(), &baseline); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(baseline.String(), "notes_content") {
+		t.Fatalf("expected the shadow tables to be left out, got %s", baseline.String())
+	}
+
+	// The virtual table creates its shadow tables again
+	comfy, err := comfylite3.New(
+		comfylite3.WithConnection("file:baseline_fts_target?mode=memory&cache=shared"),
+		comfylite3.WithBaseline(comfylite3.NewSQLMigration(1, "baseline", baseline.String(), "")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	if err := comfy.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := comfy.Exec("INSERT INTO notes (body) VALUES ('comfy notes')"); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, comfy, 1)
+}
+
+func TestBaselineIgnored(t *testing.T) {
+
+	const conn = "file:baseline_ignored?mode=memory&cache=shared"
+
+	// Keeps the shared memory database alive
+	applied, err := comfylite3.New(
+		comfylite3.WithConnection(conn),
+		comfylite3.WithMigration(tableMigration(1, "one")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer applied.Close()
+
+	if err := applied.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithConnection(conn),
+		comfylite3.WithBaseline(comfylite3.NewSQLMigration(2, "baseline", "CREATE TABLE broken (", "")),
+		comfylite3.WithMigration(tableMigration(1, "one"), tableMigration(2, "two")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	if err := comfy.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, comfy, 1, 2)
+}
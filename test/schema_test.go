@@ -0,0 +1,336 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/davidroman0O/comfylite3"
+)
+
+// Schema covering what the introspection reads
+var schemaMigration = comfylite3.NewSQLMigration(1, "schema", `
+CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT NOT NULL UNIQUE, name TEXT DEFAULT 'anonymous');
+CREATE TABLE tags (name TEXT PRIMARY KEY, color TEXT) WITHOUT ROWID;
+CREATE TABLE posts (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	tag TEXT REFERENCES tags,
+	title TEXT NOT NULL,
+	slug TEXT GENERATED ALWAYS AS (lower(title)) STORED
+) STRICT;
+CREATE INDEX posts_user ON posts (user_id, title) WHERE user_id > 0;
+CREATE VIEW user_posts AS SELECT users.name, posts.title FROM users JOIN posts ON posts.user_id = users.id;
+CREATE TRIGGER users_cleanup AFTER DELETE ON users BEGIN DELETE FROM posts WHERE user_id = OLD.id; END;
+`, `
+DROP TRIGGER users_cleanup;
+DROP VIEW user_posts;
+DROP TABLE posts;
+DROP TABLE tags;
+DROP TABLE users;
+`)
+
+func TestSchema(t *testing.T) {
+
+	comfy, err := comfylite3.New(
+		comfylite3.WithConnection("file:schema?mode=memory&cache=shared"),
+		comfylite3.WithMigration(schemaMigration),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	if err := comfy.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := comfy.Schema(context.Background(), comfylite3.SchemaOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := map[string]comfylite3.Table{}
+	for _, table := range schema.Tables {
+		tables[table.Name] = table
+	}
+	if len(tables) != 3 {
+		t.Fatalf("expected users, tags and posts without the internal tables, got %v", tables)
+	}
+
+	if !tables["tags"].WithoutRowID || tables["tags"].Strict {
+		t.Fatalf("expected tags to be WITHOUT ROWID, got %+v", tables["tags"])
+	}
+	posts := tables["posts"]
+	if !posts.Strict || posts.WithoutRowID {
+		t.Fatalf("expected posts to be STRICT, got %+v", posts)
+	}
+
+	slug := posts.Columns[len(posts.Columns)-1]
+	if slug.Name != "slug" || slug.Generated != "STORED" {
+		t.Fatalf("expected the generated slug column, got %+v", slug)
+	}
+	if !posts.Columns[0].Pk || posts.Columns[0].PkOrder != 1 {
+		t.Fatalf("expected id to be the primary key, got %+v", posts.Columns[0])
+	}
+	name := tables["users"].Columns[2]
+	if name.DfltValue == nil || *name.DfltValue != "'anonymous'" {
+		t.Fatalf("expected the default of name, got %+v", name)
+	}
+
+	if len(posts.Indexes) != 1 {
+		t.Fatalf("expected one index on posts, got %+v", posts.Indexes)
+	}
+	index := posts.Indexes[0]
+	if index.Name != "posts_user" || index.Unique || !index.Partial || index.Origin != "c" || fmt.Sprint(index.Columns) != "[user_id title]" || index.SQL == "" {
+		t.Fatalf("unexpected index %+v", index)
+	}
+	unique := tables["users"].Indexes
+	if len(unique) != 1 || !unique[0].Unique || unique[0].Origin != "u" || unique[0].SQL != "" {
+		t.Fatalf("expected the index of the UNIQUE constraint, got %+v", unique)
+	}
+
+	if len(posts.ForeignKeys) != 2 {
+		t.Fatalf("expected two foreign keys on posts, got %+v", posts.ForeignKeys)
+	}
+	for _, key := range posts.ForeignKeys {
+		switch key.Table {
+		case "users":
+			if fmt.Sprint(key.From, key.To) != "[user_id] [id]" || key.OnDelete != "CASCADE" {
+				t.Fatalf("unexpected foreign key %+v", key)
+			}
+		case "tags":
+			if fmt.Sprint(key.From) != "[tag]" || len(key.To) != 0 {
+				t.Fatalf("unexpected foreign key %+v", key)
+			}
+		default:
+			t.Fatalf("unexpected foreign key %+v", key)
+		}
+	}
+
+	if len(schema.Views) != 1 || schema.Views[0].Name != "user_posts" {
+		t.Fatalf("expected the user_posts view, got %+v", schema.Views)
+	}
+	if len(schema.Triggers) != 1 || schema.Triggers[0].Name != "users_cleanup" || schema.Triggers[0].Table != "users" {
+		t.Fatalf("expected the users_cleanup trigger, got %+v", schema.Triggers)
+	}
+
+	internal, err := comfy.Schema(context.Background(), comfylite3.SchemaOptions{IncludeInternal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, table := range internal.Tables {
+		names[table.Name] = true
+	}
+	if !names["_migrations"] || !names["sqlite_sequence"] {
+		t.Fatalf("expected the internal tables, got %v", names)
+	}
+}
+
+func TestDiffSchema(t *testing.T) {
+
+	from, err := comfylite3.New(
+		comfylite3.WithConnection("file:diff_from?mode=memory&cache=shared"),
+		comfylite3.WithMigration(comfylite3.NewSQLMigration(1, "from", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER, title TEXT, body TEXT);
+		CREATE INDEX posts_title ON posts (title);
+		CREATE TABLE legacy (id INTEGER PRIMARY KEY);
+		CREATE VIEW titles AS SELECT title FROM posts;
+		INSERT INTO users (id, name) VALUES (1, 'comfy');
+		INSERT INTO posts (id, user_id, title, body) VALUES (1, 1, 'hello', 'world');
+		`, "")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer from.Close()
+
+	to, err := comfylite3.New(
+		comfylite3.WithConnection("file:diff_to?mode=memory&cache=shared"),
+		comfylite3.WithMigration(comfylite3.NewSQLMigration(1, "to", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT NOT NULL DEFAULT '');
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL REFERENCES users(id), title TEXT);
+		CREATE UNIQUE INDEX posts_title ON posts (title);
+		CREATE TABLE comments (id INTEGER PRIMARY KEY, post_id INTEGER REFERENCES posts(id));
+		CREATE INDEX users_email ON users (email);
+		CREATE VIEW titles AS SELECT title FROM posts;
+		`, "")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer to.Close()
+
+	for _, comfy := range []*comfylite3.ComfyDB{from, to} {
+		if err := comfy.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diff, err := comfylite3.DiffSchema(context.Background(), from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]bool{}
+	for _, difference := range diff.Differences {
+		found[fmt.Sprintf("%s %s.%s", difference.Type, difference.Table, difference.Name)] = true
+	}
+	for _, expected := range []string{
+		"table legacy.legacy",
+		"table comments.comments",
+		"column users.email",
+		"column posts.user_id",
+		"column posts.body",
+		"index posts.posts_title",
+		"index users.users_email",
+		"foreign key posts.users",
+	} {
+		if !found[expected] {
+			t.Fatalf("expected the difference %s, got %+v", expected, diff.Differences)
+		}
+	}
+	if len(found) != 8 {
+		t.Fatalf("expected 8 differences, got %+v", diff.Differences)
+	}
+
+	// Applying the DDL makes both schemas the same and keeps the data
+	_, err = comfylite3.Submit(from, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		for _, statement := range diff.DDL() {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return struct{}{}, fmt.Errorf("%s: %w", statement, err)
+			}
+		}
+		return struct{}{}, nil
+	}).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err = comfylite3.DiffSchema(context.Background(), from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Differences) != 0 {
+		t.Fatalf("expected no difference after the DDL, got %+v", diff.Differences)
+	}
+
+	title, err := comfylite3.SubmitRead(from, func(ctx context.Context, db *sql.DB) (string, error) {
+		var title string
+		return title, db.QueryRowContext(ctx, "SELECT title FROM titles WHERE title = 'hello'").Scan(&title)
+	}).Get(context.Background())
+	if err != nil || title != "hello" {
+		t.Fatalf("expected the rows to be kept, got %q %v", title, err)
+	}
+}
+
+func TestDiffSchemaColumnDefinitions(t *testing.T) {
+
+	from, err := comfylite3.New(
+		comfylite3.WithConnection("file:definitions_from?mode=memory&cache=shared"),
+		comfylite3.WithMigration(comfylite3.NewSQLMigration(1, "from", `
+		CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT);
+		CREATE TABLE events (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE "odd (name)" (id INTEGER PRIMARY KEY, a TEXT, b TEXT);
+		INSERT INTO notes (id, body) VALUES (1, 'note');
+		INSERT INTO events (id, name) VALUES (1, 'event');
+		INSERT INTO "odd (name)" (id, a, b) VALUES (1, 'a', 'b');
+		`, "")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer from.Close()
+
+	to, err := comfylite3.New(
+		comfylite3.WithConnection("file:definitions_to?mode=memory&cache=shared"),
+		comfylite3.WithMigration(comfylite3.NewSQLMigration(1, "to", `
+		CREATE TABLE notes (
+			id INTEGER PRIMARY KEY,
+			body TEXT, -- the note
+			title TEXT COLLATE NOCASE DEFAULT 'none' CHECK (length(title) < 10)
+		);
+		CREATE TABLE events (id INTEGER PRIMARY KEY, name TEXT, created_at TEXT DEFAULT CURRENT_TIMESTAMP);
+		CREATE TABLE "odd (name)" (id INTEGER PRIMARY KEY, a TEXT);
+		`, "")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer to.Close()
+
+	for _, comfy := range []*comfylite3.ComfyDB{from, to} {
+		if err := comfy.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diff, err := comfylite3.DiffSchema(context.Background(), from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ddl := diff.DDL()
+
+	// The column is added as written, with its constraints
+	expected := `ALTER TABLE "notes" ADD COLUMN title TEXT COLLATE NOCASE DEFAULT 'none' CHECK (length(title) < 10)`
+	if !slices.Contains(ddl, expected) {
+		t.Fatalf("expected %s, got %v", expected, ddl)
+	}
+	// The current time can't be added with ALTER TABLE, the table is rebuilt
+	for _, statement := range ddl {
+		if strings.Contains(statement, "created_at") && strings.HasPrefix(statement, "ALTER TABLE") {
+			t.Fatalf("expected events to be rebuilt, got %s", statement)
+		}
+	}
+
+	_, err = comfylite3.Submit(from, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		for _, statement := range ddl {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return struct{}{}, fmt.Errorf("%s: %w", statement, err)
+			}
+		}
+		return struct{}{}, nil
+	}).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err = comfylite3.DiffSchema(context.Background(), from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Differences) != 0 {
+		t.Fatalf("expected no difference after the DDL, got %+v", diff.Differences)
+	}
+
+	_, err = comfylite3.Submit(from, func(ctx context.Context, db *sql.DB) (struct{}, error) {
+		var matches int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM notes WHERE title = 'NONE'").Scan(&matches); err != nil {
+			return struct{}{}, err
+		}
+		if matches != 1 {
+			return struct{}{}, fmt.Errorf("expected the collation to be kept, got %d matches", matches)
+		}
+		if _, err := db.ExecContext(ctx, "INSERT INTO notes (body, title) VALUES ('long', 'a very long title')"); err == nil {
+			return struct{}{}, errors.New("expected the check to be kept")
+		}
+		var createdAt sql.NullString
+		if err := db.QueryRowContext(ctx, "SELECT created_at FROM events WHERE id = 1").Scan(&createdAt); err != nil {
+			return struct{}{}, err
+		}
+		if !createdAt.Valid {
+			return struct{}{}, errors.New("expected the default of the rebuilt table")
+		}
+		var a string
+		return struct{}{}, db.QueryRowContext(ctx, `SELECT a FROM "odd (name)" WHERE id = 1`).Scan(&a)
+	}).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,196 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davidroman0O/comfylite3"
+)
+
+func TestSnapshots(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	comfy, err := comfylite3.New(
+		comfylite3.WithPath(filepath.Join(t.TempDir(), "comfy.db")),
+		comfylite3.WithReadPool(2),
+		comfylite3.WithSnapshots(dir, 20*time.Millisecond, comfylite3.SnapshotRetention{Count: 2}),
+		comfylite3.WithSnapshotErrorHandler(func(err error) {
+			t.Error(err)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	fillBackup(t, comfy)
+
+	// Wait for a snapshot to be taken in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshots, err := comfy.ListSnapshots()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshots) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a snapshot to be taken")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	taken, err := comfy.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The background snapshots prune the one taken, the restores read a copy
+	snapshot := filepath.Join(t.TempDir(), "restored.db")
+	copyFile(t, taken.Path, snapshot)
+
+	if _, err := comfy.Exec("DELETE FROM items"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The work queued during the restore waits for it
+	var wg sync.WaitGroup
+	counts := make(chan int, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			count, err := comfylite3.Submit(comfy, func(ctx context.Context, db *sql.DB) (int, error) {
+				var count int
+				return count, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM items").Scan(&count)
+			}).Get(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			counts <- count
+		}()
+	}
+
+	if err := comfy.RestoreSnapshot(context.Background(), snapshot); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(counts)
+	for count := range counts {
+		if count != 0 && count != 2000 {
+			t.Fatalf("expected the items to be deleted or restored, got %d", count)
+		}
+	}
+
+	if count := countItems(t, comfy); count != 2000 {
+		t.Fatalf("expected 2000 items once restored, got %d", count)
+	}
+
+	// Concurrent restores take turns and the queue goes on afterwards
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	errs := make(chan error, 3)
+	for range 3 {
+		go func() {
+			errs <- comfy.RestoreSnapshot(ctx, snapshot)
+		}()
+	}
+	for range 3 {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := countItems(t, comfy); count != 2000 {
+		t.Fatalf("expected 2000 items once restored, got %d", count)
+	}
+
+	// A missing snapshot isn't created empty and restored
+	if err := comfy.RestoreSnapshot(context.Background(), filepath.Join(t.TempDir(), "missing.db")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the snapshot to be missing, got %v", err)
+	}
+	if count := countItems(t, comfy); count != 2000 {
+		t.Fatalf("expected the database to be left alone, got %d items", count)
+	}
+
+	// Close and Shutdown racing stop the snapshots once
+	var closing sync.WaitGroup
+	closing.Add(2)
+	go func() {
+		defer closing.Done()
+		comfy.Close()
+	}()
+	go func() {
+		defer closing.Done()
+		comfy.Shutdown(context.Background())
+	}()
+	closing.Wait()
+}
+
+func TestRestoreCorruptSnapshot(t *testing.T) {
+
+	dir := t.TempDir()
+	comfy, err := comfylite3.New(
+		comfylite3.WithPath(filepath.Join(dir, "comfy.db")),
+		comfylite3.WithSnapshots(filepath.Join(dir, "snapshots"), 0, comfylite3.SnapshotRetention{Count: 2}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comfy.Close()
+
+	fillBackup(t, comfy)
+
+	var snapshot comfylite3.Snapshot
+	for range 3 {
+		if snapshot, err = comfy.Snapshot(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := comfy.ListSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[1].Path != snapshot.Path {
+		t.Fatalf("expected the 2 latest snapshots to be kept, got %+v", snapshots)
+	}
+
+	// Overwrite a page in the middle of the snapshot
+	file, err := os.OpenFile(snapshot.Path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := make([]byte, 4096)
+	for i := range garbage {
+		garbage[i] = 0xaa
+	}
+	if _, err := file.WriteAt(garbage, 4096*3); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if err := comfy.RestoreSnapshot(context.Background(), snapshot.Path); !errors.Is(err, comfylite3.ErrSnapshotCorrupt) {
+		t.Fatalf("expected ErrSnapshotCorrupt, got %v", err)
+	}
+	if count := countItems(t, comfy); count != 2000 {
+		t.Fatalf("expected the database to be left alone, got %d items", count)
+	}
+}
+
+// Copy the file at src to dst.
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	content, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}